@@ -0,0 +1,112 @@
+// Command relocate-files moves files uploaded before per-user storage keys
+// were introduced from the shared upload directory into the configured
+// storage backend, and records the new key of every file.
+//
+// Several users could upload files with the same name into the shared
+// directory, so every such row gets its own copy of the last stored bytes.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/alekslesik/file-cloud/pkg/models/mysql"
+)
+
+func main() {
+	const op = "main()"
+
+	cfg := config.New()
+
+	dir := flag.String("dir", "./website/upload", "Legacy shared upload directory")
+	remove := flag.Bool("remove", false, "Remove relocated files from the legacy directory")
+	flag.Parse()
+
+	db, err := models.OpenDB(cfg.MySQL.DSN, models.MYSQL)
+	if err != nil {
+		log.Fatalf("%s > open db error: %v", op, err)
+	}
+	defer db.Close()
+
+	str, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Fatalf("%s > open storage error: %v", op, err)
+	}
+
+	fm := &mysql.FileModel{DB: db}
+
+	files, err := fm.WithoutStorageKey()
+	if err != nil {
+		log.Fatalf("%s > get files error: %v", op, err)
+	}
+
+	ctx := context.Background()
+	relocated := map[string]bool{}
+	var failed int
+
+	for _, f := range files {
+		src := filepath.Join(*dir, filepath.Base(f.Name))
+
+		if err := relocate(ctx, str, fm, f, src); err != nil {
+			log.Printf("%s > file %d %q: %v", op, f.ID, f.Name, err)
+			failed++
+			continue
+		}
+
+		relocated[src] = true
+		log.Printf("file %d %q relocated", f.ID, f.Name)
+	}
+
+	if *remove {
+		for src := range relocated {
+			if err := os.Remove(src); err != nil {
+				log.Printf("%s > remove %s: %v", op, src, err)
+			}
+		}
+	}
+
+	log.Printf("%d files relocated, %d failed", len(files)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Copy legacy file src to a new key of the file owner and save the key
+func relocate(ctx context.Context, str storage.Backend, fm *mysql.FileModel, f *models.File, src string) error {
+	file, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("missing in legacy directory")
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	key, err := storage.NewKey(f.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err = str.Put(ctx, key, file, fi.Size()); err != nil {
+		return err
+	}
+
+	if err = fm.SetStorageKey(f.ID, key); err != nil {
+		str.Delete(ctx, key)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Share file with another user POST /files/:id/access
+func (e *Endpoint) FileAccessPost(w http.ResponseWriter, r *http.Request) {
+	f, ok := e.urlFile(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	// The grant belongs to the user sharing, in organizations the owner of
+	// the file is whoever uploaded it
+	g := &models.Grant{
+		OwnerID: e.ses.GetInt(r, template.UserID),
+		FileID:  f.ID,
+	}
+
+	e.grantAccess(w, r, g, f.Name, fmt.Sprintf("/files/%d", f.ID))
+}
+
+// Share folder with another user POST /folders/:id/access
+func (e *Endpoint) FolderAccessPost(w http.ResponseWriter, r *http.Request) {
+	folder, ok := e.urlFolder(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	g := &models.Grant{
+		OwnerID:  e.ses.GetInt(r, template.UserID),
+		FolderID: folder.ID,
+	}
+
+	e.grantAccess(w, r, g, folder.Name, folderURL(folder.OrgID, folder.ID))
+}
+
+// Stop sharing file or folder POST /access/:id/revoke
+func (e *Endpoint) AccessRevokePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AccessRevokePost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid grant id"))
+		return
+	}
+
+	g, err := e.mdl.Grants.Get(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get grant from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Users may also leave items shared with them
+	userID := e.ses.GetInt(r, template.UserID)
+	manages, err := e.managesGrant(userID, g)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get grant permission", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	if !manages && g.UserID != userID {
+		e.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+		return
+	}
+
+	if err = e.mdl.Grants.Delete(g.ID); err != nil {
+		e.log.Err(err).Msgf("%s > delete grant", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	redirect := "/shared"
+	if manages {
+		redirect = folderURL(0, g.FolderID)
+		if g.FileID != 0 {
+			redirect = fmt.Sprintf("/files/%d", g.FileID)
+		}
+	}
+
+	e.ses.Put(r, "flash", "Access removed")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// Report whether the user manages the grant: the user who granted it or
+// anybody else owning its item, e.g. other administrators of the organization
+func (e *Endpoint) managesGrant(userID int, g *models.Grant) (bool, error) {
+	if g.OwnerID == userID {
+		return true, nil
+	}
+
+	var perm models.Permission
+	if g.FileID != 0 {
+		f, err := e.mdl.Files.Get(g.FileID)
+		if err != nil {
+			return false, err
+		}
+
+		if perm, err = e.permission(userID, f.UserID, f.OrgID, f.ID, f.FolderID); err != nil {
+			return false, err
+		}
+	} else {
+		folder, err := e.mdl.Folders.Get(g.FolderID)
+		if err != nil {
+			return false, err
+		}
+
+		if perm, err = e.permission(userID, folder.UserID, folder.OrgID, 0, folder.ID); err != nil {
+			return false, err
+		}
+	}
+
+	return perm == models.Owner, nil
+}
+
+// Shared with me page GET /shared
+func (e *Endpoint) SharedGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.SharedGet()"
+
+	grants, err := e.mdl.Grants.Shared(e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get shared items from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "shared.page.html", &template.TemplateData{
+		UserName: e.ses.GetString(r, template.UserName),
+		Flash:    e.ses.PopString(r, "flash"),
+		Grants:   grants,
+	})
+}
+
+// Grant the user with email from the form access to the item and notify
+// them by email. Redirect back to the item afterwards.
+func (e *Endpoint) grantAccess(w http.ResponseWriter, r *http.Request, g *models.Grant, name, redirect string) {
+	const op = "endpoint.grantAccess()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("share access error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("email", strings.TrimSpace(form.Get("email")))
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+	form.PermittedValues("permission", string(models.Viewer), string(models.Editor))
+
+	if !form.Valid() {
+		e.ses.Put(r, "flash", "Enter an email address and choose a permission")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	user, err := e.mdl.Users.GetByEmail(form.Get("email"))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.ses.Put(r, "flash", fmt.Sprintf("There is no user with email %s", form.Get("email")))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if user.ID == g.OwnerID {
+		e.ses.Put(r, "flash", "You can't share with yourself")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	g.UserID = user.ID
+	g.Permission = models.Permission(form.Get("permission"))
+
+	if err = e.mdl.Grants.Insert(g); err != nil {
+		e.log.Err(err).Msgf("%s > insert grant to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	data := struct {
+		Name       string
+		OwnerName  string
+		ItemName   string
+		Permission models.Permission
+		URL        string
+	}{
+		Name:       user.Name,
+		OwnerName:  e.ses.GetString(r, template.UserName),
+		ItemName:   name,
+		Permission: g.Permission,
+		URL:        e.baseURL() + redirect,
+	}
+
+	go func() {
+		if err := e.mlr.Send(user.Email, "item_shared.html", data); err != nil {
+			e.log.Err(err).Msgf("%s > mail send error", op)
+		}
+	}()
+
+	e.ses.Put(r, "flash", fmt.Sprintf("%q shared with %s", name, user.Name))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
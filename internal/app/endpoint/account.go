@@ -0,0 +1,173 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Account page with passkeys, two-factor settings, personal access tokens and
+// the version limit GET /user/account
+func (e *Endpoint) AccountGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AccountGet()"
+
+	userID := e.ses.GetInt(r, template.UserID)
+
+	tokens, err := e.mdl.Tokens.Personal(userID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get tokens from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	left, err := e.mdl.RecoveryCodes.Remaining(userID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > count recovery codes", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	passkeys, err := e.mdl.Passkeys.ForUser(userID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get passkeys from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "account.page.html", &template.TemplateData{
+		UserName:      e.ses.GetString(r, template.UserName),
+		Flash:         e.ses.PopString(r, "flash"),
+		Form:          forms.New(nil),
+		Tokens:        tokens,
+		NewToken:      e.ses.PopString(r, "new-token"),
+		RecoveryCodes: strings.Fields(e.ses.PopString(r, "recovery-codes")),
+		RecoveryLeft:  left,
+		Passkeys:      passkeys,
+		MaxVersions:   e.cfg.Files.MaxVersions,
+	})
+}
+
+// Set how many prior versions of each file are kept POST /user/versions
+func (e *Endpoint) AccountVersionsPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AccountVersionsPost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("set version limit POST /user/versions error"))
+		return
+	}
+
+	limit := e.cfg.Files.MaxVersions
+
+	n, err := strconv.Atoi(strings.TrimSpace(r.PostForm.Get("max_versions")))
+	if err != nil || n < 0 || n > limit {
+		e.ses.Put(r, "flash", fmt.Sprintf("Keep 0 to %d prior versions", limit))
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	// The configured number is the default, so raising it raises the limit
+	if n == limit {
+		n = models.DefaultMaxVersions
+	}
+
+	if err = e.mdl.Users.SetMaxVersions(e.ses.GetInt(r, template.UserID), n); err != nil {
+		e.log.Err(err).Msgf("%s > set version limit", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Version limit saved, extra versions are deleted with the next upload of a file")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Create personal access token POST /user/tokens
+func (e *Endpoint) AccountTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AccountTokenCreatePost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("create token POST /user/tokens error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("name", strings.TrimSpace(form.Get("name")))
+	form.Required("name")
+	form.MaxLength("name", 100)
+	form.PermittedValues("expires_in", "", "7", "30", "90", "365")
+
+	scopes := r.PostForm["scopes"]
+	if len(scopes) == 0 || !personalScopes(scopes) {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+
+	if !form.Valid() {
+		e.ses.Put(r, "flash", "Token name must be 1-100 characters with at least one scope")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	days := 30
+	if v := form.Get("expires_in"); v != "" {
+		days, _ = strconv.Atoi(v)
+	}
+
+	token, err := e.mdl.Tokens.NewPersonal(e.ses.GetInt(r, template.UserID), form.Get("name"), scopes, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > create token", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// The plaintext is shown once on the next page
+	e.ses.Put(r, "new-token", token.Plaintext)
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Revoke personal access token POST /user/tokens/:id/revoke
+func (e *Endpoint) AccountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AccountTokenRevokePost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid token id"))
+		return
+	}
+
+	err = e.mdl.Tokens.DeletePersonal(e.ses.GetInt(r, template.UserID), id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > delete token", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Token revoked")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Report whether all scopes can be given to personal access tokens
+func personalScopes(scopes []string) bool {
+	for _, s := range scopes {
+		found := false
+		for _, p := range models.PersonalScopes {
+			if s == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
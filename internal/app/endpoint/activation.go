@@ -0,0 +1,144 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Time an activation link sent by email is valid
+const activationTTL = 3 * 24 * time.Hour
+
+// Activation page GET /user/activate?token=:token
+func (e *Endpoint) UserActivateGet(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(url.Values{})
+	form.Set("token", r.URL.Query().Get("token"))
+	if form.Get("token") == "" {
+		form.Errors.Add("generic", "Open the link from the activation email or request a new one below")
+	}
+
+	e.tmpl.Render(w, r, "activate.page.html", &template.TemplateData{
+		Flash: e.ses.PopString(r, "flash"),
+		Form:  form,
+	})
+}
+
+// Activate user by the emailed token POST /user/activate
+func (e *Endpoint) UserActivatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.UserActivatePost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("activate user POST /user/activate error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	token, err := e.mdl.Tokens.Get(models.ScopeActivation, strings.TrimSpace(form.Get("token")))
+	if errors.Is(err, models.ErrNoRecord) {
+		form.Errors.Add("generic", "The activation link is invalid or expired, request a new one below")
+		e.tmpl.Render(w, r, "activate.page.html", &template.TemplateData{Form: form})
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get token from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.Users.Activate(token.UserID); err != nil {
+		e.log.Err(err).Msgf("%s > activate user", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.Tokens.DeleteAllForUser(models.ScopeActivation, token.UserID); err != nil {
+		e.log.Err(err).Msgf("%s > delete activation tokens", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Your email is confirmed. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Send a new activation email POST /user/activation
+func (e *Endpoint) UserActivationResendPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.UserActivationResendPost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("resend activation POST /user/activation error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("email", strings.TrimSpace(form.Get("email")))
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		e.ses.Put(r, "flash", "Enter a valid email address")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// The response doesn't reveal whether the account exists
+	user, err := e.mdl.Users.GetByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err == nil && !user.Activated {
+		if err = e.mdl.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID); err != nil {
+			e.log.Err(err).Msgf("%s > delete activation tokens", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		if err = e.sendActivation(user.ID, user.Name, user.Email, "user_activation.html"); err != nil {
+			e.log.Err(err).Msgf("%s > send activation", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("If %s needs activation, a new link has been sent to it", form.Get("email")))
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Create an activation token of the user and email its link with the mail
+// template in the background
+func (e *Endpoint) sendActivation(userID int, name, email, tmpl string) error {
+	const op = "endpoint.sendActivation()"
+
+	token, err := e.mdl.Tokens.New(userID, activationTTL, models.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Name    string
+		URL     string
+		Expires time.Time
+	}{
+		Name:    name,
+		URL:     e.baseURL() + "/user/activate?token=" + url.QueryEscape(token.Plaintext),
+		Expires: token.Expiry.UTC(),
+	}
+
+	go func() {
+		if err := e.mlr.Send(email, tmpl, data); err != nil {
+			e.log.Err(err).Msgf("%s > mail send error", op)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Storage quotas page GET /admin/quotas
+func (e *Endpoint) AdminQuotasGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AdminQuotasGet()"
+
+	users, err := e.mdl.Quotas.Users(e.cfg.Files.Quota)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get users usage from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	orgs, err := e.mdl.Quotas.Orgs(e.cfg.Files.Quota)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get organizations usage from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	lockouts, err := e.mdl.Logins.Lockouts()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get lockouts from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "quotas.page.html", &template.TemplateData{
+		UserName:  e.ses.GetString(r, template.UserName),
+		Flash:     e.ses.PopString(r, "flash"),
+		Quotas:    users,
+		OrgQuotas: orgs,
+		Quota:     e.cfg.Files.Quota,
+		Lockouts:  lockouts,
+	})
+}
+
+// Set storage quota of user POST /admin/users/:id/quota
+func (e *Endpoint) AdminUserQuotaPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid user id"))
+		return
+	}
+
+	e.setQuota(w, r, id, 0)
+}
+
+// Set storage quota of organization POST /admin/orgs/:id/quota
+func (e *Endpoint) AdminOrgQuotaPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid organization id"))
+		return
+	}
+
+	e.setQuota(w, r, 0, id)
+}
+
+// Set quota of the space from the form. The quota is entered in GB, empty
+// value resets it to the default and 0 means unlimited.
+func (e *Endpoint) setQuota(w http.ResponseWriter, r *http.Request, userID, orgID int) {
+	const op = "endpoint.setQuota()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("set quota error"))
+		return
+	}
+
+	quota := models.DefaultQuota
+	if v := strings.TrimSpace(r.PostForm.Get("quota")); v != "" {
+		gb, err := strconv.ParseFloat(v, 64)
+		if err != nil || gb < 0 || gb > 1<<20 {
+			e.ses.Put(r, "flash", "Quota must be a number of GB, 0 for unlimited")
+			http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+			return
+		}
+		quota = int64(gb * (1 << 30))
+	}
+
+	err := e.mdl.Quotas.Set(userID, orgID, quota)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > set quota", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Quota saved")
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
+
+// Turn two-factor authentication of user off, e.g. after a lost device
+// POST /admin/users/:id/2fa/reset
+func (e *Endpoint) AdminUserTwoFactorResetPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AdminUserTwoFactorResetPost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid user id"))
+		return
+	}
+
+	user, err := e.mdl.Users.Get(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.Users.SetTOTP(user.ID, ""); err != nil {
+		e.log.Err(err).Msgf("%s > remove secret", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.RecoveryCodes.DeleteAll(user.ID); err != nil {
+		e.log.Err(err).Msgf("%s > delete recovery codes", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.log.Info().Msgf("%s > two-factor authentication of user %d reset by %d", op, user.ID, template.AuthenticatedUser(r).ID)
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Two-factor authentication of %s is off", user.Email))
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
+
+// Unlock an account locked after failed logins POST /admin/lockouts/:id/delete
+func (e *Endpoint) AdminLockoutDeletePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.AdminLockoutDeletePost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid lockout id"))
+		return
+	}
+
+	email, err := e.mdl.Logins.Unlock(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > unlock", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.log.Info().Msgf("%s > login of %s unlocked by %d", op, email, template.AuthenticatedUser(r).ID)
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Login of %s is unlocked", email))
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
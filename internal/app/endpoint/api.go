@@ -0,0 +1,310 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Time an API token obtained with email and password is valid
+const apiTokenTTL = 24 * time.Hour
+
+// Maximum size of JSON request bodies
+const maxJSONSize = 1 << 20
+
+// Envelope wrapping JSON responses of the API
+type envelope map[string]any
+
+// Pagination details of API list responses
+type apiPage struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+type apiUser struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Created time.Time `json:"created"`
+	Usage   apiUsage  `json:"usage"`
+}
+
+// Quota is zero for unlimited storage
+type apiUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+// Create API token POST /api/v1/tokens
+func (e *Endpoint) APITokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APITokenCreatePost()"
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		// One-time or recovery code of users with two-factor authentication
+		OTP string `json:"otp"`
+	}
+
+	if err := e.readJSON(w, r, &input); err != nil {
+		e.er.APIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fields := map[string]string{}
+	if strings.TrimSpace(input.Email) == "" {
+		fields["email"] = "must be provided"
+	}
+	if input.Password == "" {
+		fields["password"] = "must be provided"
+	}
+	if len(fields) > 0 {
+		e.er.APIFailedValidation(w, fields)
+		return
+	}
+
+	email := strings.TrimSpace(input.Email)
+
+	attempt, refused, err := e.loginAttempt(r, email)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin login attempt", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+	if refused != "" {
+		e.er.APIError(w, http.StatusTooManyRequests, refused)
+		return
+	}
+
+	id, _, err := e.ath.Authenticate(email, input.Password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		if err != models.ErrInvalidCredentials {
+			e.log.Err(err).Msgf("%s > authenticate user", op)
+		}
+
+		if err = e.loginFailed(r, email); err != nil {
+			e.log.Err(err).Msgf("%s > record failed login", op)
+			e.er.APIServerError(w, err)
+			return
+		}
+
+		e.er.APIError(w, http.StatusUnauthorized, "invalid authentication credentials")
+		return
+	}
+
+	if errors.Is(err, models.ErrNotActivated) {
+		if err := e.mdl.Logins.Succeed(email); err != nil {
+			e.log.Err(err).Msgf("%s > forget failed logins", op)
+			e.er.APIServerError(w, err)
+			return
+		}
+
+		e.er.APIError(w, http.StatusForbidden, "the email address must be confirmed first")
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > authenticate user", op)
+		e.releaseLogin(attempt)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	user, err := e.mdl.Users.Get(id)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.releaseLogin(attempt)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	if user.TwoFactor() {
+		_, err = e.checkSecondFactor(user, input.OTP)
+		if errors.Is(err, models.ErrInvalidCode) {
+			if err = e.loginFailed(r, email); err != nil {
+				e.log.Err(err).Msgf("%s > record failed login", op)
+				e.er.APIServerError(w, err)
+				return
+			}
+
+			e.er.APIError(w, http.StatusUnauthorized, "a valid one-time code must be provided in otp")
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > check code", op)
+			e.releaseLogin(attempt)
+			e.er.APIServerError(w, err)
+			return
+		}
+	}
+
+	if err = e.mdl.Logins.Succeed(email); err != nil {
+		e.log.Err(err).Msgf("%s > forget failed logins", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	token, err := e.mdl.Tokens.New(id, apiTokenTTL, models.ScopeAuthentication)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > create token", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusCreated, envelope{"token": envelope{
+		"token":  token.Plaintext,
+		"expiry": token.Expiry.UTC(),
+	}}, nil)
+}
+
+// Revoke the API token of the request DELETE /api/v1/tokens
+func (e *Endpoint) APITokenDelete(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APITokenDelete()"
+
+	_, plaintext, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	if err := e.mdl.Tokens.Delete(strings.TrimSpace(plaintext)); err != nil {
+		e.log.Err(err).Msgf("%s > delete token", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"message": "token revoked"}, nil)
+}
+
+// Current user GET /api/v1/user
+func (e *Endpoint) APIUserGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APIUserGet()"
+
+	user := template.AuthenticatedUser(r)
+
+	usage, err := e.spaceUsage(user.ID, 0)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get storage usage from DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"user": apiUser{
+		ID:      user.ID,
+		Name:    user.Name,
+		Email:   user.Email,
+		Created: user.Created,
+		Usage:   apiUsage{Used: usage.Used, Quota: usage.Quota},
+	}}, nil)
+}
+
+// Unknown API route
+func (e *Endpoint) APINotFound(w http.ResponseWriter, r *http.Request) {
+	e.er.APIError(w, http.StatusNotFound, "the requested resource could not be found")
+}
+
+// Write data as JSON response with the status and extra headers
+func (e *Endpoint) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) {
+	const op = "endpoint.writeJSON()"
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > marshal response", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// Decode JSON request body into dst. Unknown fields, trailing data and
+// bodies over maxJSONSize are rejected with an error describing the problem
+// for the client.
+func (e *Endpoint) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONSize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON at character %d", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &typeError):
+			if typeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", typeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type at character %d", typeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if err = dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// Read page and page_size query parameters. Invalid values are added to
+// fields.
+func readPage(r *http.Request, fields map[string]string) (page, size int) {
+	page, size = 1, 20
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10_000_000 {
+			fields["page"] = "must be a number between 1 and 10000000"
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			fields["page_size"] = "must be a number between 1 and 100"
+		}
+		size = n
+	}
+
+	return page, size
+}
+
+// Return pagination details of the page of total records
+func newAPIPage(page, size, total int) apiPage {
+	if total == 0 {
+		return apiPage{}
+	}
+
+	return apiPage{
+		CurrentPage:  page,
+		PageSize:     size,
+		FirstPage:    1,
+		LastPage:     (total + size - 1) / size,
+		TotalRecords: total,
+	}
+}
@@ -0,0 +1,286 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type apiFile struct {
+	ID       int       `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum,omitempty"`
+	FolderID int       `json:"folder_id,omitempty"`
+	OrgID    int       `json:"org_id,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+func newAPIFile(f *models.File) apiFile {
+	return apiFile{
+		ID:       f.ID,
+		Name:     f.Name,
+		Type:     f.Type,
+		Size:     f.Size,
+		Checksum: f.Checksum,
+		FolderID: f.FolderID,
+		OrgID:    f.OrgID,
+		Created:  f.Created,
+	}
+}
+
+// List files of a folder GET /api/v1/files?folder=:id&org=:id&page=:n&page_size=:n
+func (e *Endpoint) APIFilesGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APIFilesGet()"
+
+	fields := map[string]string{}
+	page, size := readPage(r, fields)
+	if len(fields) > 0 {
+		e.er.APIFailedValidation(w, fields)
+		return
+	}
+
+	folder, org, ok := e.apiPlace(w, r, models.Viewer)
+	if !ok {
+		return
+	}
+
+	// Shared folders are listed with files of their owner
+	userID := template.AuthenticatedUser(r).ID
+	var folderID, orgID int
+	if folder != nil {
+		folderID = folder.ID
+		orgID = folder.OrgID
+		userID = folder.UserID
+	} else if org != nil {
+		orgID = org.ID
+	}
+
+	files, total, err := e.mdl.Files.Page(userID, orgID, folderID, size, (page-1)*size)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get files from DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	list := make([]apiFile, 0, len(files))
+	for _, f := range files {
+		list = append(list, newAPIFile(f))
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"files": list, "metadata": newAPIPage(page, size, total)}, nil)
+}
+
+// Upload file with the request body as contents POST /api/v1/files?name=:name&folder=:id&org=:id
+func (e *Endpoint) APIFileCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APIFileCreatePost()"
+
+	maxSize := e.cfg.Files.MaxUploadSize
+	if r.ContentLength > maxSize {
+		e.er.APIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file must not be larger than %d bytes", maxSize))
+		return
+	}
+
+	name := filepath.Base(strings.TrimSpace(r.URL.Query().Get("name")))
+	if msg := fileNameProblem(name); msg != "" {
+		e.er.APIFailedValidation(w, map[string]string{"name": msg})
+		return
+	}
+
+	folder, org, ok := e.apiPlace(w, r, models.Editor)
+	if !ok {
+		return
+	}
+
+	fileType := r.Header.Get("Content-Type")
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+
+	userID := template.AuthenticatedUser(r).ID
+	f := &models.File{
+		Name:       name,
+		Type:       fileType,
+		UserID:     userID,
+		UploaderID: userID,
+	}
+	placeFile(f, folder, org)
+
+	id, err := e.storeFile(r.Context(), f, r.Body, maxSize)
+	if errors.Is(err, models.ErrQuotaExceeded) {
+		usage, err := e.spaceUsage(f.UserID, f.OrgID)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get storage usage from DB", op)
+			e.er.APIServerError(w, err)
+			return
+		}
+		e.er.APIError(w, http.StatusRequestEntityTooLarge, quotaMessage(usage))
+		return
+	} else if errors.Is(err, errFileTooLarge) {
+		e.er.APIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file must not be larger than %d bytes", maxSize))
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > store file", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	stored, err := e.mdl.Files.Get(id)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get file from DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/files/%d", id))
+
+	e.writeJSON(w, http.StatusCreated, envelope{"file": newAPIFile(stored)}, headers)
+}
+
+// File details GET /api/v1/files/:id
+func (e *Endpoint) APIFileGet(w http.ResponseWriter, r *http.Request) {
+	f, ok := e.apiFile(w, r, models.Viewer)
+	if !ok {
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"file": newAPIFile(f)}, nil)
+}
+
+// Download file contents GET /api/v1/files/:id/download
+func (e *Endpoint) APIFileDownloadGet(w http.ResponseWriter, r *http.Request) {
+	f, ok := e.apiFile(w, r, models.Viewer)
+	if !ok {
+		return
+	}
+
+	e.serveFile(w, r, f)
+}
+
+// Rename file PATCH /api/v1/files/:id
+func (e *Endpoint) APIFilePatch(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APIFilePatch()"
+
+	f, ok := e.apiFile(w, r, models.Editor)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	if err := e.readJSON(w, r, &input); err != nil {
+		e.er.APIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if msg := fileNameProblem(name); msg != "" {
+			e.er.APIFailedValidation(w, map[string]string{"name": msg})
+			return
+		}
+
+		err := e.mdl.Files.Rename(f.ID, name)
+		if errors.Is(err, models.ErrDuplicateName) {
+			e.er.APIError(w, http.StatusConflict, "a file with this name already exists in the folder")
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > rename file", op)
+			e.er.APIServerError(w, err)
+			return
+		}
+		f.Name = name
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"file": newAPIFile(f)}, nil)
+}
+
+// Move file to the trash DELETE /api/v1/files/:id
+func (e *Endpoint) APIFileDelete(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.APIFileDelete()"
+
+	f, ok := e.apiFile(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	if err := e.mdl.Files.Trash(f.ID); err != nil {
+		e.log.Err(err).Msgf("%s > move file to trash", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, envelope{"message": "file moved to the trash"}, nil)
+}
+
+// Return the file from :id URL parameter on which the authenticated user has
+// the need permission. Otherwise write the JSON error response and return
+// false.
+func (e *Endpoint) apiFile(w http.ResponseWriter, r *http.Request, need models.Permission) (*models.File, bool) {
+	const op = "endpoint.apiFile()"
+
+	f, err := e.userFile(r, r.URL.Query().Get(":id"), false, need)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.APINotFound(w, r)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get file from DB", op)
+		e.er.APIServerError(w, err)
+		return nil, false
+	}
+
+	return f, true
+}
+
+// Return the folder and the organization from folder and org query
+// parameters on which the authenticated user has the need permission. Both
+// are nil for the user's root folder. Otherwise write the JSON error response
+// and return false.
+func (e *Endpoint) apiPlace(w http.ResponseWriter, r *http.Request, need models.Permission) (*models.Folder, *models.Org, bool) {
+	const op = "endpoint.apiPlace()"
+
+	folder, _, err := e.userFolder(r, r.URL.Query().Get("folder"), need)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.APIFailedValidation(w, map[string]string{"folder": "no such folder"})
+		return nil, nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get folder from DB", op)
+		e.er.APIServerError(w, err)
+		return nil, nil, false
+	}
+
+	if folder != nil {
+		return folder, nil, true
+	}
+
+	org, _, err := e.userOrg(r, r.URL.Query().Get("org"), need)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.APIFailedValidation(w, map[string]string{"org": "no such organization"})
+		return nil, nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get organization from DB", op)
+		e.er.APIServerError(w, err)
+		return nil, nil, false
+	}
+
+	return nil, org, true
+}
+
+// Return why the file name is invalid or an empty string if it's valid
+func fileNameProblem(name string) string {
+	if name == "" || name == "." || len(name) > 255 || strings.ContainsAny(name, `/\`) {
+		return "must be 1-255 characters without / and \\"
+	}
+
+	return ""
+}
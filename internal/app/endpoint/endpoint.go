@@ -1,18 +1,25 @@
 package endpoint
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
 
+	"github.com/alekslesik/file-cloud/internal/pkg/auth"
 	"github.com/alekslesik/file-cloud/internal/pkg/mailer"
 	"github.com/alekslesik/file-cloud/internal/pkg/model"
 	"github.com/alekslesik/file-cloud/internal/pkg/session"
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
 	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/config"
 	"github.com/alekslesik/file-cloud/pkg/forms"
 	"github.com/alekslesik/file-cloud/pkg/logging"
 	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/justinas/nosurf"
 )
 
 // Declare a string containing the application version number. Later in the book we'll
@@ -27,6 +34,9 @@ type Template interface {
 type ClientServerError interface {
 	ClientError(http.ResponseWriter, int, error)
 	ServerError(http.ResponseWriter, error)
+	APIError(http.ResponseWriter, int, string)
+	APIFailedValidation(http.ResponseWriter, map[string]string)
+	APIServerError(http.ResponseWriter, error)
 }
 
 type Endpoint struct {
@@ -36,9 +46,16 @@ type Endpoint struct {
 	mdl  *model.Model
 	ses  session.Session
 	mlr  *mailer.Mailer
+	str  storage.Backend
+	ath  auth.Authenticator
+	cfg  *config.Config
+
+	// Relying party of single sign-on, discovered on first use
+	oidcMu sync.Mutex
+	oidc   *oidcClient
 }
 
-func New(tmpl Template, log *logging.Logger, er ClientServerError, mdl *model.Model, ses session.Session, mlr *mailer.Mailer) *Endpoint {
+func New(tmpl Template, log *logging.Logger, er ClientServerError, mdl *model.Model, ses session.Session, mlr *mailer.Mailer, str storage.Backend, ath auth.Authenticator, cfg *config.Config) *Endpoint {
 	return &Endpoint{
 		tmpl: tmpl,
 		log:  log,
@@ -46,6 +63,9 @@ func New(tmpl Template, log *logging.Logger, er ClientServerError, mdl *model.Mo
 		mdl:  mdl,
 		ses:  ses,
 		mlr:  mlr,
+		str:  str,
+		ath:  ath,
+		cfg:  cfg,
 	}
 }
 
@@ -68,11 +88,19 @@ func (e *Endpoint) HomeGet(w http.ResponseWriter, r *http.Request) {
 
 // Login user GET /login.
 func (e *Endpoint) UserLoginGet(w http.ResponseWriter, r *http.Request) {
-	flash := e.ses.PopString(r, "flash")
-	e.tmpl.Render(w, r, "login.page.html", &template.TemplateData{
-		Flash: flash,
-		Form:  forms.New(nil),
-	})
+	td := e.loginData(forms.New(nil))
+	td.Flash = e.ses.PopString(r, "flash")
+	e.tmpl.Render(w, r, "login.page.html", td)
+}
+
+// Return template data of the login page with the form
+func (e *Endpoint) loginData(form *forms.Form) *template.TemplateData {
+	td := &template.TemplateData{Form: form}
+	if e.cfg.OIDC.Issuer != "" {
+		td.SSOName = e.cfg.OIDC.Name
+	}
+
+	return td
 }
 
 // Login user POST /login.
@@ -91,27 +119,102 @@ func (e *Endpoint) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	email := form.Get("email")
 	password := form.Get("password")
-	id, userName, err := e.mdl.Users.Authenticate(email, password)
 
-	if err == models.ErrInvalidCredentials {
+	attempt, refused, err := e.loginAttempt(r, email)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin login attempt", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	if refused != "" {
+		form.Errors.Add("generic", refused)
+		e.tmpl.Render(w, r, "login.page.html", e.loginData(form))
+		return
+	}
+
+	id, _, err := e.ath.Authenticate(email, password)
+
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		if err != models.ErrInvalidCredentials {
+			e.log.Err(err).Msgf("%s > authenticate user", op)
+		}
+
+		if err = e.loginFailed(r, email); err != nil {
+			e.log.Err(err).Msgf("%s > record failed login", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
 		form.Errors.Add("generic", "Email or Password is incorrect")
-		td := &template.TemplateData{}
-		td.Form = form
-		e.tmpl.Render(w, r, "login.page.html", td)
+		e.tmpl.Render(w, r, "login.page.html", e.loginData(form))
+		return
+	}
+
+	if err == models.ErrNotActivated {
+		// The password is right, the account isn't activated yet
+		if err := e.mdl.Logins.Succeed(email); err != nil {
+			e.log.Err(err).Msgf("%s > forget failed logins", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		form.Errors.Add("activation", "Confirm your email with the link we sent you before logging in")
+		e.tmpl.Render(w, r, "login.page.html", e.loginData(form))
 		return
 	} else if err != nil {
+		e.log.Err(err).Msgf("%s > authenticate user", op)
+		e.releaseLogin(attempt)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	user, err := e.mdl.Users.Get(id)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.releaseLogin(attempt)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Users with two-factor authentication enter a code first. Failed logins
+	// are forgotten after the code, so the password doesn't reset wrong codes.
+	if user.TwoFactor() {
+		if err = e.mdl.Logins.Release(attempt); err != nil {
+			e.log.Err(err).Msgf("%s > release login attempt", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		e.ses.Put(r, pendingUserID, user.ID)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	if err := e.mdl.Logins.Succeed(email); err != nil {
+		e.log.Err(err).Msgf("%s > forget failed logins", op)
 		e.er.ServerError(w, err)
 		return
 	}
 
-	// Add the ID of the current user to the session
-	e.ses.Put(r, template.UserID, id)
-	e.ses.Put(r, template.UserName, userName)
+	e.logIn(r, user)
 
 	// Redirect the user to the create snippet page.
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// Add the ID of the current user to the session
+func (e *Endpoint) logIn(r *http.Request, user *models.User) {
+	e.ses.Put(r, template.UserID, user.ID)
+	e.ses.Put(r, template.UserName, user.Name)
+	e.ses.Put(r, template.SessionVersion, user.SessionVersion)
+}
+
+// Return the public URL of the site links in mails and pages are built from.
+// It is configured, the Host header of requests is set by the client.
+func (e *Endpoint) baseURL() string {
+	return strings.TrimRight(e.cfg.App.BaseURL, "/")
+}
+
 // Sign up user GET /user/signup
 func (e *Endpoint) UserSignupGet(w http.ResponseWriter, r *http.Request) {
 	e.tmpl.Render(w, r, "signup.page.html", &template.TemplateData{
@@ -147,7 +250,7 @@ func (e *Endpoint) UserSignupPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create a new user record in the database.
-	err = e.mdl.Users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	id, err := e.mdl.Users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
 	if err == models.ErrDuplicateEmail {
 		e.log.Err(err).Msgf("%s > duplicate email", op)
 		form.Errors.Add("email", "Address is already in use")
@@ -161,20 +264,16 @@ func (e *Endpoint) UserSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add a confirmation flash message to the session
-	e.ses.Put(r, "flash", "Your signup was successful. Please log in.")
-
-	// Send confirmation email to user
-	email := form.Get("email")
-	name := form.Get("name")
+	// Send welcome email with the activation link to user
+	err = e.sendActivation(id, form.Get("name"), form.Get("email"), "user_welcome.html")
+	if err != nil {
+		e.log.Err(err).Msgf("%s > send activation", op)
+		e.er.ServerError(w, err)
+		return
+	}
 
-	go func() {
-		err = e.mlr.Send(email, "user_welcome.html", struct{ Name string }{Name: name})
-		if err != nil {
-			e.log.Err(err).Msgf("%s > mail send error", op)
-			return
-		}
-	}()
+	// Add a confirmation flash message to the session
+	e.ses.Put(r, "flash", "Your signup was successful. Confirm your email with the link we sent you, then log in.")
 
 	// Send the client a 202 Accepted status code an redirect to /user/login
 	// This status code indicates that the request has been accepted for processing, but
@@ -189,13 +288,14 @@ func (e *Endpoint) UserLogoutGet(w http.ResponseWriter, r *http.Request) {
 	// Remove userID from session.
 	e.ses.Remove(r, template.UserID)
 	e.ses.Remove(r, template.UserName)
+	e.ses.Remove(r, template.SessionVersion)
 	// Add flash to session.
 	e.ses.Put(r, "flash", "You've been logged out successfully!")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// Files page GET /files
+// Files page GET /files?folder=:id or GET /files?org=:id
 func (e *Endpoint) FileUploadGet(w http.ResponseWriter, r *http.Request) {
 	const op = "endpoint.FileUploadGet()"
 
@@ -203,17 +303,142 @@ func (e *Endpoint) FileUploadGet(w http.ResponseWriter, r *http.Request) {
 
 	// check user authenticate
 	if template.AuthenticatedUser(r) != nil {
-		files, err := e.mdl.Files.All(userId)
+		folder, perm, err := e.userFolder(r, r.URL.Query().Get("folder"), models.Viewer)
+		if errors.Is(err, models.ErrNoRecord) {
+			e.er.ClientError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > get folder from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		// Shared folders are listed with contents of their owner, folders of
+		// organizations with contents of the organization.
+		var folderId, orgId int
+		ownerId := userId
+		if folder != nil {
+			folderId = folder.ID
+			ownerId = folder.UserID
+			orgId = folder.OrgID
+		} else {
+			var org *models.Org
+			org, perm, err = e.userOrg(r, r.URL.Query().Get("org"), models.Viewer)
+			if errors.Is(err, models.ErrNoRecord) {
+				e.er.ClientError(w, http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				e.log.Err(err).Msgf("%s > get organization from DB", op)
+				e.er.ServerError(w, err)
+				return
+			}
+			if org != nil {
+				orgId = org.ID
+			}
+		}
+
+		orgs, err := e.mdl.Orgs.ForUser(userId)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get organizations from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		var org *models.Org
+		for _, o := range orgs {
+			if o.ID == orgId {
+				org = o
+			}
+		}
+
+		breadcrumbs, err := e.mdl.Folders.Path(folderId)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get folder path from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		// Folders above the shared one are not shown to other users
+		if orgId == 0 && ownerId != userId {
+			for len(breadcrumbs) > 1 {
+				p, err := e.folderPermission(r, breadcrumbs[0])
+				if err != nil {
+					e.log.Err(err).Msgf("%s > get folder permission", op)
+					e.er.ServerError(w, err)
+					return
+				}
+				if p != "" {
+					break
+				}
+				breadcrumbs = breadcrumbs[1:]
+			}
+		}
+
+		var grants []*models.Grant
+		if folder != nil && perm == models.Owner {
+			grants, err = e.mdl.Grants.Folder(folder.ID)
+			if err != nil {
+				e.log.Err(err).Msgf("%s > get folder grants from DB", op)
+				e.er.ServerError(w, err)
+				return
+			}
+		}
+
+		folders, err := e.mdl.Folders.Children(ownerId, orgId, folderId)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get subfolders from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		allFolders, err := e.mdl.Folders.All(userId, orgId)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get all folders from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		files, err := e.mdl.Files.All(ownerId, orgId, folderId)
 		if err != nil {
 			e.log.Err(err).Msgf("%s > get all files from DB", op)
 			e.er.ServerError(w, err)
 			return
 		}
 
+		saved, err := e.mdl.Files.DedupSavings(userId)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get dedup savings from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		// Usage of other users' spaces is not shown in their shared folders
+		var usage *models.Usage
+		if orgId != 0 || ownerId == userId {
+			usage, err = e.spaceUsage(ownerId, orgId)
+			if err != nil {
+				e.log.Err(err).Msgf("%s > get storage usage from DB", op)
+				e.er.ServerError(w, err)
+				return
+			}
+		}
+
 		userName := e.ses.GetString(r, template.UserName)
+		flash := e.ses.PopString(r, "flash")
 		e.tmpl.Render(w, r, "files.page.html", &template.TemplateData{
-			UserName: userName,
-			Files:    files,
+			UserName:     userName,
+			Flash:        flash,
+			Files:        files,
+			Folder:       folder,
+			Folders:      folders,
+			AllFolders:   allFolders,
+			Breadcrumbs:  breadcrumbs,
+			DedupSavings: saved,
+			Usage:        usage,
+			Permission:   perm,
+			Grants:       grants,
+			Org:          org,
+			Orgs:         orgs,
 		})
 	} else {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -221,75 +446,235 @@ func (e *Endpoint) FileUploadGet(w http.ResponseWriter, r *http.Request) {
 }
 
 // Files page POST /files
+//
+// The body is read part by part and the file is piped straight to the storage,
+// so uploads of any size don't need memory or temporary files. The CSRF token
+// must precede the file in the form.
 func (e *Endpoint) FileUploadPost(w http.ResponseWriter, r *http.Request) {
 	const op = "endpoint.FileUploadPost()"
 
-	// Parse request body
-	r.ParseMultipartForm(32 << 20)
+	maxSize := e.cfg.Files.MaxUploadSize
+	// Leave some room for the other form fields and multipart boundaries.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
 
-	// Get file from POST
-	file, fHeader, err := r.FormFile("file")
+	mr, err := r.MultipartReader()
 	if err != nil {
-		e.log.Err(err).Msgf("%s > get file from post", op)
+		e.log.Err(err).Msgf("%s > read multipart body", op)
 		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("files page POST /files error"))
+		return
 	}
-	defer file.Close()
-	fileType := fHeader.Header.Get("Content-Type")
-	fileName := fHeader.Filename
-	fileSize := fHeader.Size
-	fileURL := "/download/" + fHeader.Filename
 
-	userId := e.ses.GetInt(r, template.UserID)
+	var csrfChecked bool
+	var folder *models.Folder
+	var org *models.Org
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > read multipart part", op)
+			e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("files page POST /files error"))
+			return
+		}
 
-	// Try to create a new user record in the database. If the email already exist
-	// add an error message to the form and re-display it.
-	_, err = e.mdl.Files.Insert(fileName, fileType, fileSize, fileURL, userId)
-	if err != nil {
-		e.log.Err(err).Msgf("%s > insert file to DB", op)
-		e.er.ServerError(w, err)
+		switch part.FormName() {
+		case nosurf.FormFieldName:
+			token, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil || !nosurf.VerifyToken(nosurf.Token(r), string(token)) {
+				e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid CSRF token"))
+				return
+			}
+			csrfChecked = true
+
+		case "folder":
+			id, err := io.ReadAll(io.LimitReader(part, 32))
+			if err != nil {
+				e.er.ClientError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			folder, _, err = e.userFolder(r, string(id), models.Editor)
+			if errors.Is(err, models.ErrNoRecord) {
+				e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid folder"))
+				return
+			} else if err != nil {
+				e.log.Err(err).Msgf("%s > get folder from DB", op)
+				e.er.ServerError(w, err)
+				return
+			}
+
+		case "org":
+			id, err := io.ReadAll(io.LimitReader(part, 32))
+			if err != nil {
+				e.er.ClientError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			org, _, err = e.userOrg(r, string(id), models.Editor)
+			if errors.Is(err, models.ErrNoRecord) {
+				e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid organization"))
+				return
+			} else if err != nil {
+				e.log.Err(err).Msgf("%s > get organization from DB", op)
+				e.er.ServerError(w, err)
+				return
+			}
+
+		case "file":
+			if !csrfChecked {
+				e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("missing CSRF token"))
+				return
+			}
+
+			fileName := part.FileName()
+			if fileName == "" {
+				e.ses.Put(r, "flash", "Please choose a file")
+				http.Redirect(w, r, "/files", http.StatusSeeOther)
+				return
+			}
+
+			fileType := part.Header.Get("Content-Type")
+			if fileType == "" {
+				fileType = "application/octet-stream"
+			}
+
+			f := &models.File{
+				Name:       fileName,
+				Type:       fileType,
+				UserID:     e.ses.GetInt(r, template.UserID),
+				UploaderID: e.ses.GetInt(r, template.UserID),
+			}
+			placeFile(f, folder, org)
+
+			_, err = e.storeFile(r.Context(), f, part, maxSize)
+			if errors.Is(err, models.ErrQuotaExceeded) {
+				usage, err := e.spaceUsage(f.UserID, f.OrgID)
+				if err != nil {
+					e.log.Err(err).Msgf("%s > get storage usage from DB", op)
+					e.er.ServerError(w, err)
+					return
+				}
+				e.ses.Put(r, "flash", quotaMessage(usage))
+				http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+				return
+			} else if errors.Is(err, errFileTooLarge) {
+				e.ses.Put(r, "flash", fmt.Sprintf("File is too large, maximum size is %s", template.HumanSize(maxSize)))
+				http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+				return
+			} else if err != nil {
+				e.log.Err(err).Msgf("%s > store file", op)
+				e.er.ServerError(w, err)
+				return
+			}
+
+			// Redirect the user to the folder of the file.
+			http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+			return
+		}
+	}
+
+	e.ses.Put(r, "flash", "Please choose a file")
+	http.Redirect(w, r, "/files", http.StatusSeeOther)
+}
+
+// Download file GET /files/:id/download
+func (e *Endpoint) FileDownloadGet(w http.ResponseWriter, r *http.Request) {
+	f, ok := e.urlFile(w, r, models.Viewer)
+	if !ok {
 		return
 	}
 
-	newFile := "./website/upload/" + fHeader.Filename
+	e.serveFile(w, r, f)
+}
 
-	// Create/open file from /upload dir
-	f, err := os.OpenFile(newFile, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
+// Send contents of the file. Range requests, including several ranges at
+// once, and conditional requests by ETag and modification time are handled,
+// so browsers can resume downloads and seek in media.
+func (e *Endpoint) serveFile(w http.ResponseWriter, r *http.Request, f *models.File) {
+	const op = "endpoint.serveFile()"
+
+	// Open file from the storage
+	file, err := e.str.Get(r.Context(), f.StorageKey)
+	if errors.Is(err, storage.ErrNotExist) {
+		e.log.Error().Msgf("%s > file %d missing in storage", op, f.ID)
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
 		e.log.Err(err).Msgf("%s > open file", op)
 		e.er.ServerError(w, err)
+		return
 	}
-	defer f.Close()
+	defer file.Close()
 
-	// Write got file to /upload
-	io.Copy(f, file)
+	fileType := f.Type
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
 
-	//
+	// Set header for downloading file
+	w.Header().Set("Content-Type", fileType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", fileETag(f))
 
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/files", http.StatusSeeOther)
+	// Send file
+	http.ServeContent(w, r, f.Name, f.Created, file)
 }
 
-// Download page GET /download/:filename
-func (e *Endpoint) FileDownloadGet(w http.ResponseWriter, r *http.Request) {
-	const op = "endpoint.FileDownloadGet()"
+// Return the entity tag of the file contents. Contents never change for the
+// same checksum. Files uploaded before checksums were recorded are identified
+// by ID, size and upload time.
+func fileETag(f *models.File) string {
+	if f.Checksum == "" {
+		return fmt.Sprintf(`"%d-%d-%d"`, f.ID, f.Size, f.Created.Unix())
+	}
 
-	// Get file name from URL parameter
-	filename := r.URL.Query().Get(":filename")
+	return fmt.Sprintf(`"%s"`, f.Checksum)
+}
 
-	// Full filepath on server
-	filePath := "./website/upload/" + filename
+// Return permission of the authenticated user on the file
+func (e *Endpoint) filePermission(r *http.Request, f *models.File) (models.Permission, error) {
+	user := template.AuthenticatedUser(r)
+	if user == nil {
+		return "", nil
+	}
 
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		e.log.Err(err).Msgf("%s > open file", op)
-		e.er.ServerError(w, err)
+	return e.permission(user.ID, f.UserID, f.OrgID, f.ID, f.FolderID)
+}
+
+// Return permission of the user on an item of the owner or, if orgID is not
+// zero, of the organization. Owners have all permissions and members of
+// organizations those of their role. Other users have permissions granted on
+// the file or the folder and its parents, zero IDs are skipped.
+func (e *Endpoint) permission(userID, ownerID, orgID, fileID, folderID int) (models.Permission, error) {
+	if orgID != 0 {
+		m, err := e.mdl.Orgs.Member(orgID, userID)
+		if err == nil {
+			return m.Role.Permission(), nil
+		} else if !errors.Is(err, models.ErrNoRecord) {
+			return "", err
+		}
+	} else if ownerID == userID {
+		return models.Owner, nil
 	}
-	defer file.Close()
 
-	// Set header for downloading file
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	return e.mdl.Grants.Permission(userID, fileID, folderID)
+}
 
-	// Send file
-	io.Copy(w, file)
+// Put file f into the folder, or into the root folder of the organization if
+// the folder is nil and the organization isn't. Files in shared folders of
+// users belong to the folder owner.
+func placeFile(f *models.File, folder *models.Folder, org *models.Org) {
+	switch {
+	case folder != nil:
+		f.FolderID = folder.ID
+		f.OrgID = folder.OrgID
+		if folder.OrgID == 0 {
+			f.UserID = folder.UserID
+		}
+	case org != nil:
+		f.OrgID = org.ID
+	}
 }
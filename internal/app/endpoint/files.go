@@ -0,0 +1,172 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Move file to the trash POST /files/:id/delete
+func (e *Endpoint) FileDeletePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FileDeletePost()"
+
+	f, ok := e.urlFile(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	if err := e.mdl.Files.Trash(f.ID); err != nil {
+		e.log.Err(err).Msgf("%s > move file to trash", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("File %q moved to the trash", f.Name))
+	http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+}
+
+// Rename file POST /files/:id/rename
+func (e *Endpoint) FileRenamePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FileRenamePost()"
+
+	f, ok := e.urlFile(w, r, models.Editor)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("rename file POST /files/:id/rename error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("name", strings.TrimSpace(form.Get("name")))
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	if !form.Valid() || strings.ContainsAny(form.Get("name"), `/\`) {
+		e.ses.Put(r, "flash", "File name must be 1-255 characters without / and \\")
+		http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+		return
+	}
+
+	err := e.mdl.Files.Rename(f.ID, form.Get("name"))
+	if errors.Is(err, models.ErrDuplicateName) {
+		e.ses.Put(r, "flash", fmt.Sprintf("File %q already exists", form.Get("name")))
+		http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > rename file", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+}
+
+// Move file to another folder POST /files/:id/move
+func (e *Endpoint) FileMovePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FileMovePost()"
+
+	f, ok := e.urlFile(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("move file POST /files/:id/move error"))
+		return
+	}
+
+	folder, _, err := e.userFolder(r, r.PostForm.Get("folder"), models.Owner)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get folder from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Files can't be moved to another space
+	var folderID int
+	if folder != nil {
+		if folder.OrgID != f.OrgID || (f.OrgID == 0 && folder.UserID != f.UserID) {
+			e.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+			return
+		}
+		folderID = folder.ID
+	}
+
+	err = e.mdl.Files.Move(f.ID, folderID)
+	if errors.Is(err, models.ErrDuplicateName) {
+		e.ses.Put(r, "flash", fmt.Sprintf("File %q already exists there", f.Name))
+		http.Redirect(w, r, folderURL(f.OrgID, f.FolderID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > move file", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, folderURL(f.OrgID, folderID), http.StatusSeeOther)
+}
+
+// Return the file from :id URL parameter on which the authenticated user has
+// the need permission. Otherwise write the error response and return false.
+func (e *Endpoint) urlFile(w http.ResponseWriter, r *http.Request, need models.Permission) (*models.File, bool) {
+	return e.urlFileIn(w, r, false, need)
+}
+
+// Same as urlFile() for the user's own files in the trash
+func (e *Endpoint) urlTrashedFile(w http.ResponseWriter, r *http.Request) (*models.File, bool) {
+	return e.urlFileIn(w, r, true, models.Owner)
+}
+
+func (e *Endpoint) urlFileIn(w http.ResponseWriter, r *http.Request, trashed bool, need models.Permission) (*models.File, bool) {
+	const op = "endpoint.urlFileIn()"
+
+	f, err := e.userFile(r, r.URL.Query().Get(":id"), trashed, need)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get file from DB", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	return f, true
+}
+
+// Return the file by ID from a URL value on which the authenticated user has
+// the need permission, in the trash or outside of it. Missing files and files
+// without the permission are both reported as ErrNoRecord, so file IDs can't
+// be probed.
+func (e *Endpoint) userFile(r *http.Request, value string, trashed bool, need models.Permission) (*models.File, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return nil, models.ErrNoRecord
+	}
+
+	f, err := e.mdl.Files.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	perm, err := e.filePermission(r, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if !perm.Includes(need) || f.Deleted.IsZero() == trashed {
+		return nil, models.ErrNoRecord
+	}
+
+	return f, nil
+}
@@ -0,0 +1,273 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Create folder POST /folders
+func (e *Endpoint) FolderCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FolderCreatePost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("create folder POST /folders error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	parent, _, err := e.userFolder(r, form.Get("parent"), models.Editor)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get parent folder from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Folders created in shared folders belong to their owner, in folders of
+	// organizations to the organization
+	userID := e.ses.GetInt(r, template.UserID)
+
+	var parentID, orgID int
+	if parent != nil {
+		parentID = parent.ID
+		userID = parent.UserID
+		orgID = parent.OrgID
+	} else {
+		org, _, err := e.userOrg(r, form.Get("org"), models.Editor)
+		if errors.Is(err, models.ErrNoRecord) {
+			e.er.ClientError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > get organization from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+		if org != nil {
+			orgID = org.ID
+		}
+	}
+
+	name, ok := e.folderName(r, form)
+	if !ok {
+		http.Redirect(w, r, folderURL(orgID, parentID), http.StatusSeeOther)
+		return
+	}
+
+	id, err := e.mdl.Folders.Insert(userID, orgID, parentID, name)
+	if errors.Is(err, models.ErrDuplicateName) {
+		e.ses.Put(r, "flash", fmt.Sprintf("Folder %q already exists", name))
+		http.Redirect(w, r, folderURL(orgID, parentID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > insert folder to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, folderURL(orgID, id), http.StatusSeeOther)
+}
+
+// Rename folder POST /folders/:id/rename
+func (e *Endpoint) FolderRenamePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FolderRenamePost()"
+
+	folder, ok := e.urlFolder(w, r, models.Editor)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("rename folder POST /folders/:id/rename error"))
+		return
+	}
+
+	name, ok := e.folderName(r, forms.New(r.PostForm))
+	if !ok {
+		http.Redirect(w, r, folderURL(folder.OrgID, folder.ParentID), http.StatusSeeOther)
+		return
+	}
+
+	err := e.mdl.Folders.Rename(folder.ID, name)
+	if errors.Is(err, models.ErrDuplicateName) {
+		e.ses.Put(r, "flash", fmt.Sprintf("Folder %q already exists", name))
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > rename folder", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, folderURL(folder.OrgID, folder.ParentID), http.StatusSeeOther)
+}
+
+// Move folder with its contents POST /folders/:id/move
+func (e *Endpoint) FolderMovePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FolderMovePost()"
+
+	folder, ok := e.urlFolder(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("move folder POST /folders/:id/move error"))
+		return
+	}
+
+	parent, _, err := e.userFolder(r, r.PostForm.Get("parent"), models.Owner)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get parent folder from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	var parentID int
+	if parent != nil {
+		parentID = parent.ID
+	}
+
+	err = e.mdl.Folders.Move(folder.ID, parentID)
+	switch {
+	case errors.Is(err, models.ErrFolderCycle):
+		e.ses.Put(r, "flash", "Folder can't be moved into itself")
+		parentID = folder.ParentID
+	case errors.Is(err, models.ErrDuplicateName):
+		e.ses.Put(r, "flash", fmt.Sprintf("Folder %q already exists there", folder.Name))
+		parentID = folder.ParentID
+	case errors.Is(err, models.ErrNoRecord):
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		e.log.Err(err).Msgf("%s > move folder", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, folderURL(folder.OrgID, parentID), http.StatusSeeOther)
+}
+
+// Return the folder by ID from a form or URL value on which the authenticated
+// user has the need permission, and the user's permission. Empty value means
+// the user's root folder, which is returned as nil. Folders without the
+// permission are reported as ErrNoRecord.
+func (e *Endpoint) userFolder(r *http.Request, value string, need models.Permission) (*models.Folder, models.Permission, error) {
+	if value == "" || value == "0" {
+		return nil, models.Owner, nil
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return nil, "", models.ErrNoRecord
+	}
+
+	folder, err := e.mdl.Folders.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	perm, err := e.folderPermission(r, folder)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !perm.Includes(need) {
+		return nil, "", models.ErrNoRecord
+	}
+
+	return folder, perm, nil
+}
+
+// Return the organization by ID from a form or URL value in which the
+// authenticated user has the need permission, and the user's permission.
+// Empty value means the user's personal space, which is returned as nil.
+// Organizations the user is not a member of are reported as ErrNoRecord.
+func (e *Endpoint) userOrg(r *http.Request, value string, need models.Permission) (*models.Org, models.Permission, error) {
+	if value == "" || value == "0" {
+		return nil, models.Owner, nil
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return nil, "", models.ErrNoRecord
+	}
+
+	m, err := e.mdl.Orgs.Member(id, template.AuthenticatedUser(r).ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !m.Role.Permission().Includes(need) {
+		return nil, "", models.ErrNoRecord
+	}
+
+	org, err := e.mdl.Orgs.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	org.Role = m.Role
+
+	return org, m.Role.Permission(), nil
+}
+
+// Return permission of the authenticated user on the folder
+func (e *Endpoint) folderPermission(r *http.Request, folder *models.Folder) (models.Permission, error) {
+	return e.permission(template.AuthenticatedUser(r).ID, folder.UserID, folder.OrgID, 0, folder.ID)
+}
+
+// Return the folder from :id URL parameter on which the user has the need
+// permission. Otherwise write the error response and return false.
+func (e *Endpoint) urlFolder(w http.ResponseWriter, r *http.Request, need models.Permission) (*models.Folder, bool) {
+	const op = "endpoint.urlFolder()"
+
+	folder, _, err := e.userFolder(r, r.URL.Query().Get(":id"), need)
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && folder == nil) {
+		e.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get folder from DB", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	return folder, true
+}
+
+// Validate folder name from the form. Put the problem to flash and return
+// false if it's invalid.
+func (e *Endpoint) folderName(r *http.Request, form *forms.Form) (string, bool) {
+	form.Set("name", strings.TrimSpace(form.Get("name")))
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	if !form.Valid() || strings.Contains(form.Get("name"), "/") {
+		e.ses.Put(r, "flash", "Folder name must be 1-255 characters without /")
+		return "", false
+	}
+
+	return form.Get("name"), true
+}
+
+// Return URL of the files page showing the folder. Root folders of
+// organizations have zero id.
+func folderURL(orgID, id int) string {
+	if id == 0 {
+		if orgID != 0 {
+			return fmt.Sprintf("/files?org=%d", orgID)
+		}
+		return "/files"
+	}
+
+	return fmt.Sprintf("/files?folder=%d", id)
+}
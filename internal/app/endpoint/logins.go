@@ -0,0 +1,142 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Begin a password login attempt of the email from the client's address. It
+// counts as failed until the login succeeds or the attempt is released, so
+// parallel attempts can't get around the delay and the lockout. The reason
+// the attempt is refused before the password is checked is returned, empty if
+// it isn't.
+func (e *Endpoint) loginAttempt(r *http.Request, email string) (int, string, error) {
+	cfg := e.cfg.Login
+
+	return e.mdl.Logins.Attempt(email, clientIP(r), time.Now().Add(-cfg.Window), func(f *models.LoginFailures) string {
+		now := time.Now()
+
+		if f.LockedUntil.After(now) {
+			return fmt.Sprintf("Too many failed logins, the account is locked until %s UTC", f.LockedUntil.UTC().Format("15:04"))
+		}
+
+		// The attempt locking the account may still be checking its password
+		if cfg.MaxAccountFailures > 0 && f.Account >= cfg.MaxAccountFailures {
+			return "Too many failed logins, try again later"
+		}
+
+		if cfg.MaxIPFailures > 0 && f.IP >= cfg.MaxIPFailures {
+			return "Too many failed logins from your network, try again later"
+		}
+
+		if wait := f.Last.Add(e.loginDelay(f.Account)).Sub(now); f.Account > 0 && wait > 0 {
+			return fmt.Sprintf("Wait %d seconds before trying again", int(math.Ceil(wait.Seconds())))
+		}
+
+		return ""
+	})
+}
+
+// Forget the login attempt when the request fails for another reason than
+// the credentials. Errors are only logged, the request fails anyway.
+func (e *Endpoint) releaseLogin(attempt int) {
+	const op = "endpoint.releaseLogin()"
+
+	if err := e.mdl.Logins.Release(attempt); err != nil {
+		e.log.Err(err).Msgf("%s > release login attempt", op)
+	}
+}
+
+// Keep the login attempt as failed and lock the account after too many
+// failures. The owner is told about the lockout by email.
+func (e *Endpoint) loginFailed(r *http.Request, email string) error {
+	const op = "endpoint.loginFailed()"
+
+	cfg := e.cfg.Login
+	ip := clientIP(r)
+
+	if cfg.MaxAccountFailures <= 0 {
+		return nil
+	}
+
+	f, err := e.mdl.Logins.Failures(email, ip, time.Now().Add(-cfg.Window))
+	if err != nil {
+		return err
+	}
+	if f.Account < cfg.MaxAccountFailures {
+		return nil
+	}
+
+	until := time.Now().Add(cfg.Lockout)
+
+	locked, err := e.mdl.Logins.Lock(email, until)
+	if err != nil || !locked {
+		return err
+	}
+
+	e.log.Info().Msgf("%s > login of %s locked after %d failures, the latest from %s", op, email, f.Account, ip)
+
+	// Emails without an account are locked the same, so lockouts don't
+	// reveal which accounts exist
+	user, err := e.mdl.Users.GetByEmail(email)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	data := struct {
+		Name     string
+		Failures int
+		IP       string
+		Until    time.Time
+		URL      string
+	}{
+		Name:     user.Name,
+		Failures: f.Account,
+		IP:       ip,
+		Until:    until.UTC(),
+		URL:      e.baseURL() + "/user/password/forgot",
+	}
+
+	go func() {
+		if err := e.mlr.Send(user.Email, "login_lockout.html", data); err != nil {
+			e.log.Err(err).Msgf("%s > mail send error", op)
+		}
+	}()
+
+	return nil
+}
+
+// Return the wait after the failures of an account, doubled by every
+// failure up to the maximum
+func (e *Endpoint) loginDelay(failures int) time.Duration {
+	cfg := e.cfg.Login
+
+	delay := cfg.Delay
+	for i := 1; i < failures && delay < cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > cfg.MaxDelay {
+		delay = cfg.MaxDelay
+	}
+
+	return delay
+}
+
+// Return the IP address of the client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,285 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Time to reach the identity provider from the server
+const oidcTimeout = 10 * time.Second
+
+// Session key of the login waiting for the provider's callback
+const oidcLogin = "oidc-login"
+
+// Relying party of the configured OpenID Connect provider
+type oidcClient struct {
+	verifier *oidc.IDTokenVerifier
+	config   oauth2.Config
+}
+
+// Values of a login bound to the browser session
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// Redirect to the identity provider GET /user/oidc/login
+func (e *Endpoint) OIDCLoginGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OIDCLoginGet()"
+
+	client, ok := e.oidcClient(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > generate state", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > generate nonce", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	login := oidcState{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+
+	js, err := json.Marshal(login)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > marshal state", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	e.ses.Put(r, oidcLogin, string(js))
+
+	url := client.config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Log in with the authorization code of the identity provider GET /user/oidc/callback
+func (e *Endpoint) OIDCCallbackGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OIDCCallbackGet()"
+
+	client, ok := e.oidcClient(w, r)
+	if !ok {
+		return
+	}
+
+	var login oidcState
+	js := e.ses.PopString(r, oidcLogin)
+	if js == "" || json.Unmarshal([]byte(js), &login) != nil {
+		e.oidcFailed(w, r, "the login expired, try again")
+		return
+	}
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		e.oidcFailed(w, r, "the login expired, try again")
+		return
+	}
+
+	if msg := query.Get("error"); msg != "" {
+		if desc := query.Get("error_description"); desc != "" {
+			msg = desc
+		}
+		e.oidcFailed(w, r, msg)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+
+	token, err := client.config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > exchange code", op)
+		e.oidcFailed(w, r, "the identity provider didn't accept the login")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		e.log.Error().Msgf("%s > no ID token in token response", op)
+		e.oidcFailed(w, r, "the identity provider didn't return an ID token")
+		return
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > verify ID token", op)
+		e.oidcFailed(w, r, "the ID token is invalid")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.Nonce)) != 1 {
+		e.log.Error().Msgf("%s > nonce mismatch", op)
+		e.oidcFailed(w, r, "the ID token is invalid")
+		return
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err = idToken.Claims(&claims); err != nil {
+		e.log.Err(err).Msgf("%s > parse claims", op)
+		e.oidcFailed(w, r, "the ID token is invalid")
+		return
+	}
+
+	user, msg, err := e.oidcUser(idToken.Issuer, idToken.Subject, claims.Email, claims.EmailVerified, claims.Name, claims.PreferredUsername)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > find user", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	if user == nil {
+		e.oidcFailed(w, r, msg)
+		return
+	}
+
+	// Users with two-factor authentication enter a code first
+	if user.TwoFactor() {
+		e.ses.Put(r, pendingUserID, user.ID)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	e.logIn(r, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Return the user linked to the subject of the issuer. Unknown subjects are
+// linked to the user with the same verified email, take it over if it was
+// never activated or are provisioned as new users. A nil user comes with the reason for the person logging in.
+func (e *Endpoint) oidcUser(issuer, subject, email string, verified bool, names ...string) (*models.User, string, error) {
+	user, err := e.mdl.Users.GetByOIDC(issuer, subject)
+	if err == nil {
+		return user, "", nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return nil, "", err
+	}
+
+	if email == "" || !verified {
+		return nil, "the identity provider didn't confirm your email address", nil
+	}
+
+	name, _, _ := strings.Cut(email, "@")
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			name = n
+			break
+		}
+	}
+
+	user, err = e.mdl.Users.GetByEmail(email)
+	if err == nil {
+		// Accounts which were never activated could be registered by anyone,
+		// they are taken over like new accounts instead of linked
+		if user.Activated {
+			err = e.mdl.Users.LinkOIDC(user.ID, issuer, subject)
+		} else {
+			err = e.mdl.Users.ReplaceOIDC(user.ID, name, issuer, subject)
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, fmt.Sprintf("%s is linked to another single sign-on account", email), nil
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		user, err = e.mdl.Users.GetByOIDC(issuer, subject)
+		return user, "", err
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return nil, "", err
+	}
+
+	if !e.cfg.OIDC.AutoProvision {
+		return nil, fmt.Sprintf("there is no account for %s, ask an administrator to create one", email), nil
+	}
+
+	id, err := e.mdl.Users.InsertOIDC(name, email, issuer, subject)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err = e.mdl.Users.Get(id)
+	return user, "", err
+}
+
+// Return the relying party, discovering the provider on first use. Writes
+// 404 if single sign-on isn't configured and redirects to the login page if
+// the provider can't be reached.
+func (e *Endpoint) oidcClient(w http.ResponseWriter, r *http.Request) (*oidcClient, bool) {
+	const op = "endpoint.oidcClient()"
+
+	cfg := e.cfg.OIDC
+	if cfg.Issuer == "" {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("single sign-on isn't configured"))
+		return nil, false
+	}
+
+	e.oidcMu.Lock()
+	defer e.oidcMu.Unlock()
+
+	if e.oidc != nil {
+		return e.oidc, true
+	}
+
+	// The provider keeps the context to fetch its keys later, so it can't be
+	// canceled and the client limits the time of every request
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: oidcTimeout})
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > discover provider %s", op, cfg.Issuer)
+		e.oidcFailed(w, r, "the identity provider can't be reached")
+		return nil, false
+	}
+
+	e.oidc = &oidcClient{
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+	}
+
+	return e.oidc, true
+}
+
+// Redirect to the login page with the reason of a failed single sign-on
+func (e *Endpoint) oidcFailed(w http.ResponseWriter, r *http.Request, reason string) {
+	e.ses.Put(r, "flash", "Single sign-on failed: "+reason)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Return a random URL safe string
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,461 @@
+package endpoint
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/model"
+	"github.com/alekslesik/file-cloud/internal/pkg/session"
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/logging"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/golangcollege/sessions"
+	"github.com/rs/zerolog"
+)
+
+const testClientID = "file-cloud"
+
+// Identity provider issuing ID tokens for the authorization codes of logins
+// it saw. Its token endpoint checks the PKCE verifier like real providers.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	logins map[string]idpLogin
+	// Claims of the next ID token, nonce and standard claims are added
+	claims map[string]any
+	// Replaces the nonce of the login in the next ID token if set
+	nonce string
+}
+
+// Authorization request the provider got for a code
+type idpLogin struct {
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, logins: map[string]idpLogin{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// Grant a code for the authorization URL the login redirected to
+func (idp *mockIdP) authorize(t *testing.T, location string) (state, code string) {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, idp.URL+"/authorize") {
+		t.Fatalf("redirected to %q; want the authorization endpoint", location)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without S256 PKCE challenge: %s", u.RawQuery)
+	}
+
+	code = "code-" + q.Get("state")
+
+	idp.mu.Lock()
+	idp.logins[code] = idpLogin{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+
+	return q.Get("state"), code
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	login, ok := idp.logins[r.FormValue("code")]
+	delete(idp.logins, r.FormValue("code"))
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != login.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   idp.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": login.nonce,
+	}
+	if idp.nonce != "" {
+		claims["nonce"] = idp.nonce
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idp.sign(claims),
+	})
+}
+
+// Return the claims as RS256 signed JWT
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Users kept in memory with the OIDC methods of the users model
+type fakeUsers struct {
+	users map[int]*models.User
+	// Issuer and subject linked to users
+	links map[int]string
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+func (f *fakeUsers) Get(id int) (*models.User, error) {
+	if u, ok := f.users[id]; ok {
+		return u, nil
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (f *fakeUsers) GetByEmail(email string) (*models.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (f *fakeUsers) GetByOIDC(issuer, subject string) (*models.User, error) {
+	for id, link := range f.links {
+		if link == issuer+" "+subject {
+			return f.users[id], nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (f *fakeUsers) InsertOIDC(name, email, issuer, subject string) (int, error) {
+	id := len(f.users) + 1
+	f.users[id] = &models.User{ID: id, Name: name, Email: email, Activated: true}
+	f.links[id] = issuer + " " + subject
+	return id, nil
+}
+
+func (f *fakeUsers) LinkOIDC(id int, issuer, subject string) error {
+	if _, linked := f.links[id]; linked || !f.users[id].Activated {
+		return models.ErrNoRecord
+	}
+	f.links[id] = issuer + " " + subject
+	return nil
+}
+
+func (f *fakeUsers) ReplaceOIDC(id int, name, issuer, subject string) error {
+	if _, linked := f.links[id]; linked || f.users[id].Activated {
+		return models.ErrNoRecord
+	}
+	f.users[id] = &models.User{ID: id, Name: name, Email: f.users[id].Email, Activated: true}
+	f.links[id] = issuer + " " + subject
+	return nil
+}
+
+func (f *fakeUsers) Insert(name, email, password string) (int, error) { return 0, errNotImplemented }
+func (f *fakeUsers) Authenticate(email, password string) (int, string, error) {
+	return 0, "", errNotImplemented
+}
+func (f *fakeUsers) Activate(id int) error                     { return errNotImplemented }
+func (f *fakeUsers) SetPassword(id int, password string) error { return errNotImplemented }
+func (f *fakeUsers) SetTOTP(id int, secret string) error       { return errNotImplemented }
+func (f *fakeUsers) UseTOTPStep(id int, step int64) error      { return errNotImplemented }
+func (f *fakeUsers) SetMaxVersions(id, n int) error            { return errNotImplemented }
+func (f *fakeUsers) SyncLDAP(dn, name, email string, admin bool) (int, error) {
+	return 0, errNotImplemented
+}
+
+// Error responses written as plain status codes
+type statusErrors struct{}
+
+func (statusErrors) ClientError(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
+func (statusErrors) ServerError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+func (statusErrors) APIError(w http.ResponseWriter, status int, msg string) {
+	http.Error(w, msg, status)
+}
+func (statusErrors) APIFailedValidation(w http.ResponseWriter, errs map[string]string) {
+	http.Error(w, "failed validation", http.StatusUnprocessableEntity)
+}
+func (statusErrors) APIServerError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// Browser logging in to the endpoint, keeping the session cookie
+type oidcBrowser struct {
+	t       *testing.T
+	handler http.Handler
+	cookies []*http.Cookie
+	// Session values seen by the last request
+	userID int
+	flash  string
+}
+
+func (b *oidcBrowser) get(path string) *httptest.ResponseRecorder {
+	b.t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "https://localhost"+path, nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	b.handler.ServeHTTP(w, r)
+
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		b.cookies = cookies
+	}
+
+	return w
+}
+
+func newOIDCTest(t *testing.T, users *fakeUsers) (*mockIdP, *oidcBrowser) {
+	idp := newMockIdP(t)
+
+	ses := session.Session{Session: sessions.New([]byte("0123456789abcdef0123456789abcdef"))}
+
+	cfg := &config.Config{}
+	cfg.OIDC = config.OIDCConfig{
+		Issuer:        idp.URL,
+		ClientID:      testClientID,
+		ClientSecret:  "secret",
+		RedirectURL:   "https://localhost/user/oidc/callback",
+		AutoProvision: true,
+	}
+
+	e := New(nil, &logging.Logger{Logger: zerolog.Nop()}, statusErrors{}, &model.Model{Users: users}, ses, nil, nil, nil, cfg)
+
+	b := &oidcBrowser{t: t}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/oidc/login", e.OIDCLoginGet)
+	mux.HandleFunc("/user/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		e.OIDCCallbackGet(w, r)
+		b.userID = ses.GetInt(r, template.UserID)
+		b.flash = ses.GetString(r, "flash")
+	})
+	b.handler = ses.Enable(mux)
+
+	return idp, b
+}
+
+// Run the login up to the provider's redirect back and return the state and
+// code of it
+func startLogin(t *testing.T, idp *mockIdP, b *oidcBrowser) (string, string) {
+	t.Helper()
+
+	w := b.get("/user/oidc/login")
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got status %d; want %d", w.Code, http.StatusFound)
+	}
+
+	return idp.authorize(t, w.Header().Get("Location"))
+}
+
+func TestOIDCCallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		users  map[int]*models.User
+		claims map[string]any
+		nonce  string
+		// Callback query built from the state and code of the login
+		query func(state, code string) string
+
+		wantLocation string
+		wantUserID   int
+		wantLinked   bool
+		wantFlash    string
+	}{
+		{
+			name:         "new user provisioned",
+			claims:       map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true, "name": "Alice"},
+			wantLocation: "/",
+			wantUserID:   1,
+			wantLinked:   true,
+		},
+		{
+			name:         "activated user linked by verified email",
+			users:        map[int]*models.User{7: {ID: 7, Name: "Bob", Email: "bob@example.com", Activated: true}},
+			claims:       map[string]any{"sub": "bob", "email": "bob@example.com", "email_verified": true},
+			wantLocation: "/",
+			wantUserID:   7,
+			wantLinked:   true,
+		},
+		{
+			name:         "unactivated user taken over",
+			users:        map[int]*models.User{7: {ID: 7, Name: "Squatter", Email: "bob@example.com"}},
+			claims:       map[string]any{"sub": "bob", "email": "bob@example.com", "email_verified": true, "name": "Bob"},
+			wantLocation: "/",
+			wantUserID:   7,
+			wantLinked:   true,
+		},
+		{
+			name:         "unverified email not linked",
+			users:        map[int]*models.User{7: {ID: 7, Name: "Bob", Email: "bob@example.com", Activated: true}},
+			claims:       map[string]any{"sub": "mallory", "email": "bob@example.com", "email_verified": false},
+			wantLocation: "/user/login",
+			wantFlash:    "didn't confirm your email",
+		},
+		{
+			name:         "wrong state",
+			claims:       map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true},
+			query:        func(state, code string) string { return "state=forged&code=" + code },
+			wantLocation: "/user/login",
+			wantFlash:    "the login expired",
+		},
+		{
+			name:         "nonce of another login",
+			claims:       map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true},
+			nonce:        "replayed",
+			wantLocation: "/user/login",
+			wantFlash:    "the ID token is invalid",
+		},
+		{
+			name:         "code of another login",
+			claims:       map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true},
+			query:        func(state, code string) string { return "state=" + state + "&code=stolen" },
+			wantLocation: "/user/login",
+			wantFlash:    "didn't accept the login",
+		},
+		{
+			name:         "error of the provider",
+			query:        func(state, code string) string { return "state=" + state + "&error=access_denied" },
+			wantLocation: "/user/login",
+			wantFlash:    "access_denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{users: map[int]*models.User{}, links: map[int]string{}}
+			for id, u := range tt.users {
+				users.users[id] = u
+			}
+
+			idp, b := newOIDCTest(t, users)
+			idp.claims = tt.claims
+			idp.nonce = tt.nonce
+
+			state, code := startLogin(t, idp, b)
+
+			query := "state=" + url.QueryEscape(state) + "&code=" + url.QueryEscape(code)
+			if tt.query != nil {
+				query = tt.query(url.QueryEscape(state), url.QueryEscape(code))
+			}
+
+			w := b.get("/user/oidc/callback?" + query)
+
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.wantLocation {
+				t.Fatalf("got %d to %q; want %d to %q", w.Code, w.Header().Get("Location"), http.StatusSeeOther, tt.wantLocation)
+			}
+
+			if b.userID != tt.wantUserID {
+				t.Errorf("got logged in user %d; want %d", b.userID, tt.wantUserID)
+			}
+
+			_, linked := users.links[tt.wantUserID]
+			if tt.wantUserID != 0 && linked != tt.wantLinked {
+				t.Errorf("got user linked %t; want %t", linked, tt.wantLinked)
+			}
+
+			if tt.wantUserID == 0 && len(users.links) > 0 {
+				t.Errorf("got links %v; want none", users.links)
+			}
+
+			if !strings.Contains(b.flash, tt.wantFlash) {
+				t.Errorf("got flash %q; want it to contain %q", b.flash, tt.wantFlash)
+			}
+		})
+	}
+}
+
+// The login state is used up by the callback, so it can't be replayed
+func TestOIDCCallbackReplay(t *testing.T) {
+	users := &fakeUsers{users: map[int]*models.User{}, links: map[int]string{}}
+	idp, b := newOIDCTest(t, users)
+	idp.claims = map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	state, code := startLogin(t, idp, b)
+	callback := "/user/oidc/callback?state=" + url.QueryEscape(state) + "&code=" + url.QueryEscape(code)
+
+	if w := b.get(callback); w.Header().Get("Location") != "/" {
+		t.Fatalf("first callback: got redirect to %q; want /", w.Header().Get("Location"))
+	}
+
+	if w := b.get(callback); w.Header().Get("Location") != "/user/login" {
+		t.Errorf("replayed callback: got redirect to %q; want /user/login", w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,414 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Time an invitation to an organization can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// Organizations page GET /orgs
+func (e *Endpoint) OrgsGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgsGet()"
+
+	orgs, err := e.mdl.Orgs.ForUser(e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get organizations from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "orgs.page.html", &template.TemplateData{
+		UserName: e.ses.GetString(r, template.UserName),
+		Flash:    e.ses.PopString(r, "flash"),
+		Form:     forms.New(nil),
+		Orgs:     orgs,
+	})
+}
+
+// Create organization POST /orgs
+func (e *Endpoint) OrgCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgCreatePost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("create organization POST /orgs error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("name", strings.TrimSpace(form.Get("name")))
+	form.Required("name")
+	form.MaxLength("name", 100)
+
+	if !form.Valid() {
+		e.ses.Put(r, "flash", "Organization name must be 1-100 characters")
+		http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		return
+	}
+
+	id, err := e.mdl.Orgs.Insert(form.Get("name"), e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > insert organization to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/%d", id), http.StatusSeeOther)
+}
+
+// Organization page GET /orgs/:id
+func (e *Endpoint) OrgGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgGet()"
+
+	org, member, ok := e.urlOrg(w, r, false)
+	if !ok {
+		return
+	}
+
+	members, err := e.mdl.Orgs.Members(org.ID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get members from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Pending invitations are shown to managers only
+	var invitations []*models.Invitation
+	if member.Role.Manages() {
+		invitations, err = e.mdl.Orgs.Invitations(org.ID)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get invitations from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	e.tmpl.Render(w, r, "org.page.html", &template.TemplateData{
+		UserName:    e.ses.GetString(r, template.UserName),
+		Flash:       e.ses.PopString(r, "flash"),
+		Org:         org,
+		Member:      member,
+		Members:     members,
+		Invitations: invitations,
+	})
+}
+
+// Invite user to organization by email POST /orgs/:id/invitations
+func (e *Endpoint) OrgInvitePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgInvitePost()"
+
+	org, member, ok := e.urlOrg(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invite POST /orgs/:id/invitations error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("email", strings.TrimSpace(form.Get("email")))
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+	form.PermittedValues("role", string(models.RoleMember), string(models.RoleAdmin), string(models.RoleOwner))
+
+	// Only owners can make new owners
+	if form.Get("role") == string(models.RoleOwner) && member.Role != models.RoleOwner {
+		form.Errors.Add("role", "Only owners can invite owners")
+	}
+
+	if !form.Valid() {
+		e.ses.Put(r, "flash", "Enter an email address and choose a role you can give")
+		http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+		return
+	}
+
+	inv := &models.Invitation{
+		OrgID: org.ID,
+		Email: form.Get("email"),
+		Role:  models.Role(form.Get("role")),
+	}
+
+	token, err := e.mdl.Orgs.Invite(inv, member.UserID, invitationTTL)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > insert invitation to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	data := struct {
+		InviterName string
+		OrgName     string
+		Role        models.Role
+		URL         string
+		Expires     time.Time
+	}{
+		InviterName: member.Name,
+		OrgName:     org.Name,
+		Role:        inv.Role,
+		URL:         e.baseURL() + "/invitations/" + token.Plaintext,
+		Expires:     token.Expiry,
+	}
+
+	go func() {
+		if err := e.mlr.Send(inv.Email, "org_invitation.html", data); err != nil {
+			e.log.Err(err).Msgf("%s > mail send error", op)
+		}
+	}()
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Invitation sent to %s", inv.Email))
+	http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+}
+
+// Cancel invitation POST /orgs/:id/invitations/:invitation/cancel
+func (e *Endpoint) OrgInvitationCancelPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgInvitationCancelPost()"
+
+	org, _, ok := e.urlOrg(w, r, true)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":invitation"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid invitation id"))
+		return
+	}
+
+	err = e.mdl.Orgs.CancelInvitation(org.ID, id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > cancel invitation", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Invitation cancelled")
+	http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+}
+
+// Change role of member POST /orgs/:id/members/:user/role
+func (e *Endpoint) OrgMemberRolePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgMemberRolePost()"
+
+	org, member, ok := e.urlOrg(w, r, true)
+	if !ok {
+		return
+	}
+
+	if member.Role != models.RoleOwner {
+		e.er.ClientError(w, http.StatusForbidden, fmt.Errorf("only owners can change roles"))
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get(":user"))
+	if err != nil || userID < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid user id"))
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("change role POST /orgs/:id/members/:user/role error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.PermittedValues("role", string(models.RoleMember), string(models.RoleAdmin), string(models.RoleOwner))
+	if !form.Valid() {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid role"))
+		return
+	}
+
+	if _, err = e.mdl.Orgs.Member(org.ID, userID); errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get member from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	err = e.mdl.Orgs.SetRole(org.ID, userID, models.Role(form.Get("role")))
+	if errors.Is(err, models.ErrLastOwner) {
+		e.ses.Put(r, "flash", "The organization must have at least one owner")
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > change member role", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+}
+
+// Remove member or leave organization POST /orgs/:id/members/:user/remove
+func (e *Endpoint) OrgMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.OrgMemberRemovePost()"
+
+	org, member, ok := e.urlOrg(w, r, false)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get(":user"))
+	if err != nil || userID < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid user id"))
+		return
+	}
+
+	// Members may leave by themselves, others are removed by managers.
+	// Admins can't remove owners.
+	if userID != member.UserID {
+		target, err := e.mdl.Orgs.Member(org.ID, userID)
+		if errors.Is(err, models.ErrNoRecord) {
+			e.er.ClientError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > get member from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		if !member.Role.Manages() || (target.Role == models.RoleOwner && member.Role != models.RoleOwner) {
+			e.er.ClientError(w, http.StatusForbidden, fmt.Errorf("not allowed to remove the member"))
+			return
+		}
+	}
+
+	err = e.mdl.Orgs.RemoveMember(org.ID, userID)
+	if errors.Is(err, models.ErrLastOwner) {
+		e.ses.Put(r, "flash", "The organization must have at least one owner")
+		http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > remove member", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if userID == member.UserID {
+		e.ses.Put(r, "flash", fmt.Sprintf("You left %s", org.Name))
+		http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Member removed")
+	http.Redirect(w, r, urlOrgPage(org.ID), http.StatusSeeOther)
+}
+
+// Invitation page GET /invitations/:token
+func (e *Endpoint) InvitationGet(w http.ResponseWriter, r *http.Request) {
+	inv, ok := e.urlInvitation(w, r)
+	if !ok {
+		return
+	}
+
+	e.tmpl.Render(w, r, "invitation.page.html", &template.TemplateData{
+		UserName:   e.ses.GetString(r, template.UserName),
+		Flash:      e.ses.PopString(r, "flash"),
+		Invitation: inv,
+	})
+}
+
+// Accept invitation POST /invitations/:token
+func (e *Endpoint) InvitationAcceptPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.InvitationAcceptPost()"
+
+	inv, ok := e.urlInvitation(w, r)
+	if !ok {
+		return
+	}
+
+	// Invitations are accepted by the account with the invited email only
+	user := template.AuthenticatedUser(r)
+	if !strings.EqualFold(user.Email, inv.Email) {
+		e.ses.Put(r, "flash", fmt.Sprintf("The invitation was sent to %s, log in with that account to accept it", inv.Email))
+		http.Redirect(w, r, "/invitations/"+r.URL.Query().Get(":token"), http.StatusSeeOther)
+		return
+	}
+
+	err := e.mdl.Orgs.Accept(r.URL.Query().Get(":token"), user.ID)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > accept invitation", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("You joined %s", inv.OrgName))
+	http.Redirect(w, r, folderURL(inv.OrgID, 0), http.StatusSeeOther)
+}
+
+// Return the organization from :id URL parameter and membership of the
+// authenticated user in it. Managers only are allowed if manage is set.
+// Otherwise write the error response and return false.
+func (e *Endpoint) urlOrg(w http.ResponseWriter, r *http.Request, manage bool) (*models.Org, *models.Member, bool) {
+	const op = "endpoint.urlOrg()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid organization id"))
+		return nil, nil, false
+	}
+
+	member, err := e.mdl.Orgs.Member(id, e.ses.GetInt(r, template.UserID))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get member from DB", op)
+		e.er.ServerError(w, err)
+		return nil, nil, false
+	}
+
+	if manage && !member.Role.Manages() {
+		e.er.ClientError(w, http.StatusForbidden, fmt.Errorf("organization managers only"))
+		return nil, nil, false
+	}
+
+	org, err := e.mdl.Orgs.Get(id)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get organization from DB", op)
+		e.er.ServerError(w, err)
+		return nil, nil, false
+	}
+	org.Role = member.Role
+
+	return org, member, true
+}
+
+// Return the unexpired invitation from :token URL parameter. Otherwise write
+// the error response and return false.
+func (e *Endpoint) urlInvitation(w http.ResponseWriter, r *http.Request) (*models.Invitation, bool) {
+	const op = "endpoint.urlInvitation()"
+
+	inv, err := e.mdl.Orgs.Invitation(r.URL.Query().Get(":token"))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get invitation from DB", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	return inv, true
+}
+
+// Return URL of the organization page
+func urlOrgPage(id int) string {
+	return fmt.Sprintf("/orgs/%d", id)
+}
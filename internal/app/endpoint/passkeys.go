@@ -0,0 +1,363 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Time to finish a passkey registration or login in the browser
+const passkeyTimeout = 5 * time.Minute
+
+// Session keys of WebAuthn ceremonies in progress
+const (
+	passkeyRegistration = "webauthn-registration"
+	passkeyLogin        = "webauthn-login"
+)
+
+// User with passkeys as seen by the WebAuthn library
+type webauthnUser struct {
+	user     *models.User
+	passkeys []*models.Passkey
+}
+
+func (u *webauthnUser) WebAuthnID() []byte          { return userHandle(u.user.ID) }
+func (u *webauthnUser) WebAuthnName() string        { return u.user.Email }
+func (u *webauthnUser) WebAuthnDisplayName() string { return u.user.Name }
+func (u *webauthnUser) WebAuthnIcon() string        { return "" }
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+
+	for _, p := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(p.Transports))
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+
+	return credentials
+}
+
+// Start adding a passkey to the account POST /user/passkeys/register/begin
+func (e *Endpoint) PasskeyRegisterBeginPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasskeyRegisterBeginPost()"
+
+	user, err := e.passkeyUser(template.AuthenticatedUser(r))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get passkeys from DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	wa, err := e.webAuthn()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > configure WebAuthn", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	// Credentials must be discoverable to log in without the email
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.passkeys))
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin registration", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	if err = e.putCeremony(r, passkeyRegistration, session); err != nil {
+		e.log.Err(err).Msgf("%s > store session data", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, creation, nil)
+}
+
+// Store the passkey created by the browser POST /user/passkeys/register/finish?name=:name
+func (e *Endpoint) PasskeyRegisterFinishPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasskeyRegisterFinishPost()"
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 100 {
+		e.er.APIFailedValidation(w, map[string]string{"name": "must not be longer than 100 characters"})
+		return
+	}
+
+	session, ok := e.popCeremony(r, passkeyRegistration)
+	if !ok {
+		e.er.APIError(w, http.StatusBadRequest, "no passkey registration in progress")
+		return
+	}
+
+	user, err := e.passkeyUser(template.AuthenticatedUser(r))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get passkeys from DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	wa, err := e.webAuthn()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > configure WebAuthn", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	credential, err := wa.FinishRegistration(user, session, r)
+	if err != nil {
+		e.er.APIError(w, http.StatusBadRequest, "the passkey couldn't be verified")
+		return
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	_, err = e.mdl.Passkeys.Insert(&models.Passkey{
+		UserID:          user.user.ID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	})
+	if err != nil {
+		e.log.Err(err).Msgf("%s > insert passkey to DB", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Passkey %s added", name))
+	e.writeJSON(w, http.StatusCreated, envelope{"redirect": "/user/account"}, nil)
+}
+
+// Remove passkey from the account POST /user/passkeys/:id/delete
+func (e *Endpoint) PasskeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasskeyDeletePost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid passkey id"))
+		return
+	}
+
+	err = e.mdl.Passkeys.Delete(template.AuthenticatedUser(r).ID, id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > delete passkey", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Passkey removed")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Start logging in with a passkey POST /user/login/passkey/begin
+func (e *Endpoint) PasskeyLoginBeginPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasskeyLoginBeginPost()"
+
+	wa, err := e.webAuthn()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > configure WebAuthn", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	assertion, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin login", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	if err = e.putCeremony(r, passkeyLogin, session); err != nil {
+		e.log.Err(err).Msgf("%s > store session data", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	e.writeJSON(w, http.StatusOK, assertion, nil)
+}
+
+// Log in with the passkey assertion of the browser POST /user/login/passkey/finish
+func (e *Endpoint) PasskeyLoginFinishPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasskeyLoginFinishPost()"
+
+	session, ok := e.popCeremony(r, passkeyLogin)
+	if !ok {
+		e.er.APIError(w, http.StatusBadRequest, "no passkey login in progress")
+		return
+	}
+
+	wa, err := e.webAuthn()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > configure WebAuthn", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		e.er.APIError(w, http.StatusBadRequest, "the passkey response is malformed")
+		return
+	}
+
+	// The credential from the authenticator identifies the user
+	var passkey *models.Passkey
+	var user *models.User
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		p, err := e.mdl.Passkeys.GetByCredentialID(rawID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(handle, userHandle(p.UserID)) {
+			return nil, fmt.Errorf("user handle of passkey %d doesn't match", p.ID)
+		}
+
+		u, err := e.mdl.Users.Get(p.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		passkey, user = p, u
+		return &webauthnUser{user: u, passkeys: []*models.Passkey{p}}, nil
+	}
+
+	credential, err := wa.ValidateDiscoverableLogin(handler, session, parsed)
+	if err != nil {
+		e.er.APIError(w, http.StatusUnauthorized, "the passkey isn't registered or couldn't be verified")
+		return
+	}
+
+	// A counter going backwards means the key may have been copied
+	if credential.Authenticator.CloneWarning {
+		e.log.Error().Msgf("%s > possibly cloned passkey %d of user %d", op, passkey.ID, user.ID)
+		e.er.APIError(w, http.StatusUnauthorized, "the passkey couldn't be verified")
+		return
+	}
+
+	if !user.Activated {
+		e.er.APIError(w, http.StatusForbidden, "confirm your email with the link we sent you before logging in")
+		return
+	}
+
+	err = e.mdl.Passkeys.Used(passkey.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > update passkey", op)
+		e.er.APIServerError(w, err)
+		return
+	}
+
+	// Without user verification the passkey is just one factor
+	if user.TwoFactor() && !credential.Flags.UserVerified {
+		e.ses.Put(r, pendingUserID, user.ID)
+		e.writeJSON(w, http.StatusOK, envelope{"redirect": "/user/login/2fa"}, nil)
+		return
+	}
+
+	e.logIn(r, user)
+	e.writeJSON(w, http.StatusOK, envelope{"redirect": "/"}, nil)
+}
+
+// Return the WebAuthn relying party of the configured domain
+func (e *Endpoint) webAuthn() (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyTimeout, TimeoutUVD: passkeyTimeout}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          e.cfg.WebAuthn.RPID,
+		RPDisplayName: serviceName,
+		RPOrigins:     e.cfg.WebAuthn.Origins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// Return the user with their passkeys
+func (e *Endpoint) passkeyUser(user *models.User) (*webauthnUser, error) {
+	passkeys, err := e.mdl.Passkeys.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthnUser{user: user, passkeys: passkeys}, nil
+}
+
+// Keep WebAuthn session data in the session until the ceremony finishes
+func (e *Endpoint) putCeremony(r *http.Request, key string, session *webauthn.SessionData) error {
+	js, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	e.ses.Put(r, key, string(js))
+	return nil
+}
+
+// Remove WebAuthn session data from the session and return it
+func (e *Endpoint) popCeremony(r *http.Request, key string) (webauthn.SessionData, bool) {
+	var session webauthn.SessionData
+
+	js := e.ses.PopString(r, key)
+	if js == "" {
+		return session, false
+	}
+
+	if err := json.Unmarshal([]byte(js), &session); err != nil {
+		return session, false
+	}
+
+	return session, true
+}
+
+// Return the WebAuthn user handle of the user ID
+func userHandle(id int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
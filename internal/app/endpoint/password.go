@@ -0,0 +1,154 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Time a password reset link sent by email is valid
+const passwordResetTTL = 45 * time.Minute
+
+// Forgot password page GET /user/password/forgot
+func (e *Endpoint) PasswordForgotGet(w http.ResponseWriter, r *http.Request) {
+	e.tmpl.Render(w, r, "forgot.page.html", &template.TemplateData{
+		Flash: e.ses.PopString(r, "flash"),
+		Form:  forms.New(nil),
+	})
+}
+
+// Send password reset email POST /user/password/forgot
+func (e *Endpoint) PasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasswordForgotPost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("forgot password POST /user/password/forgot error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("email", strings.TrimSpace(form.Get("email")))
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		e.tmpl.Render(w, r, "forgot.page.html", &template.TemplateData{Form: form})
+		return
+	}
+
+	// The response doesn't reveal whether the account exists
+	user, err := e.mdl.Users.GetByEmail(form.Get("email"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Passwords of directory users are changed in the directory
+	if err == nil && !user.LDAP {
+		// Only the latest link works
+		if err = e.mdl.Tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID); err != nil {
+			e.log.Err(err).Msgf("%s > delete password reset tokens", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		token, err := e.mdl.Tokens.New(user.ID, passwordResetTTL, models.ScopePasswordReset)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > create token", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		data := struct {
+			Name    string
+			URL     string
+			Expires time.Time
+		}{
+			Name:    user.Name,
+			URL:     e.baseURL() + "/user/password/reset?token=" + url.QueryEscape(token.Plaintext),
+			Expires: token.Expiry.UTC(),
+		}
+
+		go func() {
+			if err := e.mlr.Send(user.Email, "password_reset.html", data); err != nil {
+				e.log.Err(err).Msgf("%s > mail send error", op)
+			}
+		}()
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("If an account with %s exists, a password reset link has been sent to it", form.Get("email")))
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Reset password page GET /user/password/reset?token=:token
+func (e *Endpoint) PasswordResetGet(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(url.Values{})
+	form.Set("token", r.URL.Query().Get("token"))
+
+	e.tmpl.Render(w, r, "reset.page.html", &template.TemplateData{
+		Flash: e.ses.PopString(r, "flash"),
+		Form:  form,
+	})
+}
+
+// Set new password by the emailed token POST /user/password/reset
+func (e *Endpoint) PasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.PasswordResetPost()"
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("reset password POST /user/password/reset error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", 6)
+	if form.Get("password") != form.Get("confirm") {
+		form.Errors.Add("confirm", "Passwords don't match")
+	}
+
+	if !form.Valid() {
+		e.tmpl.Render(w, r, "reset.page.html", &template.TemplateData{Form: form})
+		return
+	}
+
+	// The token is used up before the password changes, so the link works once
+	// even for concurrent requests
+	token, err := e.mdl.Tokens.Consume(models.ScopePasswordReset, strings.TrimSpace(form.Get("token")))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.ses.Put(r, "flash", "The password reset link is invalid or expired, request a new one")
+		http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > consume token", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.Users.SetPassword(token.UserID, form.Get("password")); err != nil {
+		e.log.Err(err).Msgf("%s > set password", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// Other reset tokens, API and personal access tokens stop working
+	if err = e.mdl.Tokens.DeleteUser(token.UserID); err != nil {
+		e.log.Err(err).Msgf("%s > delete tokens", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Remove(r, template.UserID)
+	e.ses.Remove(r, template.UserName)
+	e.ses.Remove(r, template.SessionVersion)
+	e.ses.Put(r, "flash", "Your password has been changed. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
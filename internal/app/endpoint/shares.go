@@ -0,0 +1,289 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Layout of the expiry date in the share form
+const shareDateLayout = "2006-01-02"
+
+// Create share link for file POST /files/:id/shares
+func (e *Endpoint) ShareCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.ShareCreatePost()"
+
+	f, ok := e.urlFile(w, r, models.Owner)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("create share POST /files/:id/shares error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.MaxLength("password", 72)
+
+	// The link is listed and revoked by the user creating it, in
+	// organizations the owner of the file is whoever uploaded it
+	s := &models.Share{
+		FileID: f.ID,
+		UserID: e.ses.GetInt(r, template.UserID),
+	}
+
+	// The link works until the end of the chosen day
+	if v := strings.TrimSpace(form.Get("expires")); v != "" {
+		day, err := time.Parse(shareDateLayout, v)
+		if err != nil || !day.AddDate(0, 0, 1).After(time.Now()) {
+			form.Errors.Add("expires", "Expiry date must be today or later")
+		}
+		s.Expires = day.AddDate(0, 0, 1)
+	}
+
+	if v := strings.TrimSpace(form.Get("max_downloads")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			form.Errors.Add("max_downloads", "Download limit must be a positive number")
+		}
+		s.MaxDownloads = n
+	}
+
+	if !form.Valid() {
+		for _, field := range []string{"expires", "max_downloads", "password"} {
+			if msg := form.Errors.Get(field); msg != "" {
+				e.ses.Put(r, "flash", msg)
+				break
+			}
+		}
+		http.Redirect(w, r, fmt.Sprintf("/files/%d", f.ID), http.StatusSeeOther)
+		return
+	}
+
+	token, err := randomID()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > generate share token", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	s.Token = token
+
+	if _, err = e.mdl.Shares.Insert(s, form.Get("password")); err != nil {
+		e.log.Err(err).Msgf("%s > insert share to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Share link for %q created", f.Name))
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
+
+// Share links page GET /shares
+func (e *Endpoint) SharesGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.SharesGet()"
+
+	shares, err := e.mdl.Shares.All(e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get shares from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "shares.page.html", &template.TemplateData{
+		UserName: e.ses.GetString(r, template.UserName),
+		Flash:    e.ses.PopString(r, "flash"),
+		Shares:   shares,
+		BaseURL:  e.baseURL(),
+	})
+}
+
+// Revoke share link POST /shares/:id/revoke
+func (e *Endpoint) ShareRevokePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.ShareRevokePost()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid share id"))
+		return
+	}
+
+	err = e.mdl.Shares.Revoke(id, e.ses.GetInt(r, template.UserID))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > revoke share", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Share link revoked")
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
+
+// Public share page GET /s/:token
+func (e *Endpoint) SharePageGet(w http.ResponseWriter, r *http.Request) {
+	s, f, ok := e.urlShare(w, r)
+	if !ok {
+		return
+	}
+
+	e.tmpl.Render(w, r, "share.page.html", &template.TemplateData{
+		UserName: e.ses.GetString(r, template.UserName),
+		Form:     forms.New(nil),
+		Share:    s,
+		File:     f,
+		Locked:   !e.shareUnlocked(r, s),
+	})
+}
+
+// Unlock password protected share POST /s/:token
+func (e *Endpoint) SharePagePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.SharePagePost()"
+
+	s, f, ok := e.urlShare(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("unlock share POST /s/:token error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	err := e.mdl.Shares.Authenticate(s.ID, form.Get("password"))
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		form.Errors.Add("generic", "Password is incorrect")
+		e.tmpl.Render(w, r, "share.page.html", &template.TemplateData{
+			UserName: e.ses.GetString(r, template.UserName),
+			Form:     form,
+			Share:    s,
+			File:     f,
+			Locked:   true,
+		})
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > check share password", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, shareSessionKey(s), true)
+	http.Redirect(w, r, "/s/"+s.Token, http.StatusSeeOther)
+}
+
+// Download shared file GET /s/:token/download
+//
+// Every request which could send the file from its start counts towards the
+// download limit, only resuming a download doesn't use it up.
+func (e *Endpoint) ShareDownloadGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.ShareDownloadGet()"
+
+	s, f, ok := e.urlShare(w, r)
+	if !ok {
+		return
+	}
+
+	if !e.shareUnlocked(r, s) {
+		http.Redirect(w, r, "/s/"+s.Token, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet && !resumesDownload(r, f) {
+		err := e.mdl.Shares.Download(s.ID)
+		if errors.Is(err, models.ErrShareExpired) {
+			e.er.ClientError(w, http.StatusGone, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > count share download", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	e.serveFile(w, r, f)
+}
+
+// Return the share link from :token URL parameter and its file. Otherwise
+// write the error response and return false.
+func (e *Endpoint) urlShare(w http.ResponseWriter, r *http.Request) (*models.Share, *models.File, bool) {
+	const op = "endpoint.urlShare()"
+
+	s, err := e.mdl.Shares.Get(r.URL.Query().Get(":token"))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get share from DB", op)
+		e.er.ServerError(w, err)
+		return nil, nil, false
+	}
+
+	if s.Expired(time.Now()) {
+		e.er.ClientError(w, http.StatusGone, models.ErrShareExpired)
+		return nil, nil, false
+	}
+
+	f, err := e.mdl.Files.Get(s.FileID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get file from DB", op)
+		e.er.ServerError(w, err)
+		return nil, nil, false
+	}
+
+	// Links to files in the trash work again when the file is restored
+	if !f.Deleted.IsZero() {
+		e.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+		return nil, nil, false
+	}
+
+	return s, f, true
+}
+
+// Report whether the request continues an earlier download of the file. Only
+// byte ranges all starting after the first byte qualify. Suffix ranges and
+// outdated If-Range conditions can send the whole file.
+func resumesDownload(r *http.Request, f *models.File) bool {
+	ranges, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		return false
+	}
+
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != fileETag(f) {
+		return false
+	}
+
+	for _, spec := range strings.Split(ranges, ",") {
+		start, _, ok := strings.Cut(spec, "-")
+		if !ok {
+			return false
+		}
+
+		n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+		if err != nil || n <= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Report whether the share is open or its password was entered in the session
+func (e *Endpoint) shareUnlocked(r *http.Request, s *models.Share) bool {
+	return !s.Protected || e.ses.GetBool(r, shareSessionKey(s))
+}
+
+// Session key marking the password protected share as unlocked
+func shareSessionKey(s *models.Share) string {
+	return fmt.Sprintf("share-%d", s.ID)
+}
@@ -0,0 +1,105 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Trash page GET /trash
+func (e *Endpoint) TrashGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TrashGet()"
+
+	files, err := e.mdl.Files.Trashed(e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get trashed files from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "trash.page.html", &template.TemplateData{
+		UserName:       e.ses.GetString(r, template.UserName),
+		Flash:          e.ses.PopString(r, "flash"),
+		Files:          files,
+		TrashRetention: int(e.cfg.Files.TrashRetention.Hours() / 24),
+	})
+}
+
+// Restore file from the trash POST /trash/:id/restore
+func (e *Endpoint) TrashRestorePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TrashRestorePost()"
+
+	f, ok := e.urlTrashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	err := e.mdl.Files.Restore(f.ID)
+	if errors.Is(err, models.ErrDuplicateName) {
+		e.ses.Put(r, "flash", fmt.Sprintf("A file named %q exists in that folder, rename or move it, then restore this one", f.Name))
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > restore file", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("File %q restored", f.Name))
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Delete file permanently POST /trash/:id/delete
+func (e *Endpoint) TrashDeletePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TrashDeletePost()"
+
+	f, ok := e.urlTrashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	if err := e.purgeFile(r, f); err != nil {
+		e.log.Err(err).Msgf("%s > delete file", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("File %q deleted", f.Name))
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Delete all files in the trash permanently POST /trash/empty
+func (e *Endpoint) TrashEmptyPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TrashEmptyPost()"
+
+	files, err := e.mdl.Files.Trashed(e.ses.GetInt(r, template.UserID))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get trashed files from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	for _, f := range files {
+		if err = e.purgeFile(r, f); err != nil {
+			e.log.Err(err).Msgf("%s > delete file", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	e.ses.Put(r, "flash", "Trash emptied")
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// Delete the file record and its stored contents.
+//
+// The contents are removed once the record is gone and kept while other
+// files refer to them.
+func (e *Endpoint) purgeFile(r *http.Request, f *models.File) error {
+	return e.mdl.Files.Delete(f.ID, func(storageKey string) error {
+		return e.str.Delete(r.Context(), storageKey)
+	})
+}
@@ -0,0 +1,528 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Resumable uploads implementing the tus protocol 1.0.0 (https://tus.io) with
+// the creation, termination and expiration extensions.
+//
+// Every PATCH request is stored as a separate chunk object, and the chunks are
+// joined into a regular file once the whole upload is received. A request
+// interrupted in the middle leaves the upload at the offset of the last
+// complete chunk, from which the client resumes after asking with HEAD.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusPath       = "/files/tus/"
+)
+
+// Resumable upload capabilities OPTIONS /files/tus
+func (e *Endpoint) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(e.cfg.Files.MaxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create resumable upload POST /files/tus
+func (e *Endpoint) TusCreatePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TusCreatePost()"
+
+	if !e.tusResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Length"))
+		return
+	}
+	if length > e.cfg.Files.MaxUploadSize {
+		e.er.ClientError(w, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	// Keep the name only, like multipart.Part.FileName() does.
+	fileName = filepath.Base(fileName)
+	if fileName == "." || fileName == "/" {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("missing filename in Upload-Metadata"))
+		return
+	}
+
+	fileType := metadata["filetype"]
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+
+	folder, _, err := e.userFolder(r, metadata["folder"], models.Editor)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid folder in Upload-Metadata"))
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get folder from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	org, _, err := e.userOrg(r, metadata["org"], models.Editor)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid org in Upload-Metadata"))
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get organization from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	id, err := randomID()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > create upload id", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	u := &models.Upload{
+		ID:      id,
+		UserID:  e.ses.GetInt(r, template.UserID),
+		Name:    fileName,
+		Type:    fileType,
+		Length:  length,
+		Expires: time.Now().Add(e.cfg.Files.UploadExpiration),
+	}
+	if folder != nil {
+		u.FolderID = folder.ID
+		u.OrgID = folder.OrgID
+	} else if org != nil {
+		u.OrgID = org.ID
+	}
+
+	// Reject uploads which don't fit into the space before any data is sent
+	spaceOwner := u.UserID
+	if folder != nil {
+		spaceOwner = folder.UserID
+	}
+
+	usage, err := e.spaceUsage(spaceOwner, u.OrgID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get storage usage from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if !usage.Unlimited() && length > usage.Left() {
+		e.er.ClientError(w, http.StatusRequestEntityTooLarge, errors.New(quotaMessage(usage)))
+		return
+	}
+
+	if err = e.mdl.Uploads.Insert(u); err != nil {
+		e.log.Err(err).Msgf("%s > insert upload to DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// An empty file is complete right away. The upload is kept, so the client
+	// finds it complete at the Location.
+	if length == 0 {
+		if err = e.tusFinish(r.Context(), u); err != nil {
+			e.log.Err(err).Msgf("%s > finish upload", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", tusPath+u.ID)
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Resumable upload offset HEAD /files/tus/:id
+func (e *Endpoint) TusHead(w http.ResponseWriter, r *http.Request) {
+	if !e.tusResumable(w, r) {
+		return
+	}
+
+	u, ok := e.tusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Append chunk to resumable upload PATCH /files/tus/:id
+func (e *Endpoint) TusPatch(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TusPatch()"
+
+	if !e.tusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		e.er.ClientError(w, http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type"))
+		return
+	}
+
+	u, ok := e.tusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Offset"))
+		return
+	}
+	if offset != u.Offset {
+		e.er.ClientError(w, http.StatusConflict, models.ErrOffsetMismatch)
+		return
+	}
+
+	if u.Offset < u.Length {
+		size, err := e.tusStorePart(r.Context(), u, r.Body)
+		if errors.Is(err, errFileTooLarge) {
+			e.er.ClientError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		} else if errors.Is(err, models.ErrOffsetMismatch) {
+			e.er.ClientError(w, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > store upload part", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		u.Offset += size
+	}
+
+	// Finishing is repeated by the next PATCH if it failed, as the upload
+	// stays at its full length. It's done once, later requests find the
+	// upload claimed.
+	if u.Offset == u.Length {
+		err = e.tusFinish(r.Context(), u)
+		if errors.Is(err, models.ErrNoRecord) {
+			e.er.ClientError(w, http.StatusForbidden, err)
+			return
+		} else if errors.Is(err, models.ErrQuotaExceeded) {
+			e.er.ClientError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		} else if err != nil {
+			e.log.Err(err).Msgf("%s > finish upload", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Terminate resumable upload DELETE /files/tus/:id
+func (e *Endpoint) TusDelete(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TusDelete()"
+
+	if !e.tusResumable(w, r) {
+		return
+	}
+
+	u, ok := e.tusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := e.tusRemove(r.Context(), u); err != nil {
+		e.log.Err(err).Msgf("%s > remove upload", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Check the client speaks the supported protocol version and set the
+// Tus-Resumable response header.
+func (e *Endpoint) tusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		e.er.ClientError(w, http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version"))
+		return false
+	}
+
+	return true
+}
+
+// Return the upload from URL of the authenticated user. Otherwise write the
+// error response and return false.
+func (e *Endpoint) tusUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	const op = "endpoint.tusUpload()"
+
+	u, err := e.mdl.Uploads.Get(r.URL.Query().Get(":id"))
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get upload from DB", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	if u.UserID != e.ses.GetInt(r, template.UserID) {
+		e.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+		return nil, false
+	}
+
+	if time.Now().After(u.Expires) {
+		e.er.ClientError(w, http.StatusGone, fmt.Errorf("upload expired"))
+		return nil, false
+	}
+
+	return u, true
+}
+
+// Store r as the next chunk of the upload and return its size
+func (e *Endpoint) tusStorePart(ctx context.Context, u *models.Upload, r io.Reader) (int64, error) {
+	suffix, err := randomID()
+	if err != nil {
+		return 0, err
+	}
+
+	// Concurrent requests for the same offset never share a key, the one
+	// recorded in the database wins.
+	key := fmt.Sprintf("uploads/%s/%020d-%s", u.ID, u.Offset, suffix)
+
+	hr := newHashingReader(r, u.Length-u.Offset)
+
+	err = e.str.Put(ctx, key, hr, -1)
+	if hr.size > hr.limit {
+		e.str.Delete(ctx, key)
+		return 0, errFileTooLarge
+	} else if err != nil {
+		e.str.Delete(ctx, key)
+		return 0, err
+	}
+
+	if hr.size == 0 {
+		e.str.Delete(ctx, key)
+		return 0, nil
+	}
+
+	if err = e.mdl.Uploads.AddPart(u.ID, u.Offset, hr.size, key); err != nil {
+		e.str.Delete(ctx, key)
+		return 0, err
+	}
+
+	return hr.size, nil
+}
+
+// Join chunks of the complete upload into a new file of the user unless
+// another request did or does it. The chunks are removed afterwards, the
+// upload is kept until it expires.
+func (e *Endpoint) tusFinish(ctx context.Context, u *models.Upload) error {
+	const op = "endpoint.tusFinish()"
+
+	claimed, err := e.mdl.Uploads.Claim(u.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	if err = e.tusJoin(ctx, u); err != nil {
+		if err := e.mdl.Uploads.Unclaim(u.ID); err != nil {
+			e.log.Err(err).Msgf("%s > unclaim upload %s", op, u.ID)
+		}
+		return err
+	}
+
+	// The file is stored, chunks left over are removed when the upload expires
+	if err = e.tusRemoveParts(ctx, u); err != nil {
+		e.log.Err(err).Msgf("%s > remove chunks of upload %s", op, u.ID)
+	}
+
+	return nil
+}
+
+// Store the chunks of the upload as a file
+func (e *Endpoint) tusJoin(ctx context.Context, u *models.Upload) error {
+	parts, err := e.mdl.Uploads.Parts(u.ID)
+	if err != nil {
+		return err
+	}
+
+	pr := &partsReader{ctx: ctx, str: e.str, parts: parts}
+	defer pr.Close()
+
+	f := &models.File{
+		Name:       u.Name,
+		Type:       u.Type,
+		UserID:     u.UserID,
+		UploaderID: u.UserID,
+	}
+
+	// Check the folder is still shared with the user or the user is still a
+	// member of the organization
+	var folder *models.Folder
+	var org *models.Org
+	perm := models.Owner
+	switch {
+	case u.FolderID != 0:
+		if folder, err = e.mdl.Folders.Get(u.FolderID); err != nil {
+			return err
+		}
+		perm, err = e.permission(u.UserID, folder.UserID, folder.OrgID, 0, folder.ID)
+	case u.OrgID != 0:
+		if org, err = e.mdl.Orgs.Get(u.OrgID); err != nil {
+			return err
+		}
+		perm, err = e.permission(u.UserID, 0, org.ID, 0, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !perm.Includes(models.Editor) {
+		return models.ErrNoRecord
+	}
+
+	placeFile(f, folder, org)
+
+	_, err = e.storeFile(ctx, f, pr, u.Length)
+	return err
+}
+
+// Delete chunks and the upload record
+func (e *Endpoint) tusRemove(ctx context.Context, u *models.Upload) error {
+	if err := e.tusRemoveParts(ctx, u); err != nil {
+		return err
+	}
+
+	return e.mdl.Uploads.Delete(u.ID)
+}
+
+// Delete chunks of the upload
+func (e *Endpoint) tusRemoveParts(ctx context.Context, u *models.Upload) error {
+	parts, err := e.mdl.Uploads.Parts(u.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+		if err = e.str.Delete(ctx, p.StorageKey); err != nil {
+			return err
+		}
+	}
+
+	return e.mdl.Uploads.DeleteParts(u.ID)
+}
+
+// Reader of upload chunks one after another. Every chunk is opened only when
+// the previous one is read to the end.
+type partsReader struct {
+	ctx   context.Context
+	str   storage.Backend
+	parts []*models.UploadPart
+	cur   io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := p.str.Get(p.ctx, p.parts[0].StorageKey)
+			if err != nil {
+				return 0, err
+			}
+			p.cur = rc
+			p.parts = p.parts[1:]
+		}
+
+		n, err := p.cur.Read(b)
+		if err == io.EOF {
+			p.cur.Close()
+			p.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur != nil {
+		return p.cur.Close()
+	}
+
+	return nil
+}
+
+// Parse Upload-Metadata header: comma separated pairs of a key and an
+// optional base64 encoded value divided by a space.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, " ")
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %s", key)
+		}
+
+		metadata[key] = string(decoded)
+	}
+
+	return metadata, nil
+}
+
+// Return random 128-bit hex encoded ID
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
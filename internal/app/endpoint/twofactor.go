@@ -0,0 +1,356 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/forms"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/alekslesik/file-cloud/pkg/totp"
+)
+
+const (
+	// Name of the service in authenticator apps and passkey prompts
+	serviceName = "File Cloud"
+	// Recovery codes generated at once
+	recoveryCodeCount = 10
+)
+
+// Session key of a login waiting for the second factor. Wrong codes are
+// counted as failed logins of the account, not in the session the client
+// could replay.
+const pendingUserID = "2fa-user"
+
+// Second login step GET /user/login/2fa
+func (e *Endpoint) LoginTwoFactorGet(w http.ResponseWriter, r *http.Request) {
+	if !e.ses.Exists(r, pendingUserID) {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	e.tmpl.Render(w, r, "twofactor.page.html", &template.TemplateData{
+		Flash: e.ses.PopString(r, "flash"),
+		Form:  forms.New(nil),
+	})
+}
+
+// Check the one-time or recovery code of the second login step POST /user/login/2fa
+func (e *Endpoint) LoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.LoginTwoFactorPost()"
+
+	userID := e.ses.GetInt(r, pendingUserID)
+	if userID == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("second factor POST /user/login/2fa error"))
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	user, err := e.mdl.Users.Get(userID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get user from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	attempt, refused, err := e.loginAttempt(r, user.Email)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin login attempt", op)
+		e.er.ServerError(w, err)
+		return
+	}
+	if refused != "" {
+		form.Errors.Add("code", refused)
+		e.tmpl.Render(w, r, "twofactor.page.html", &template.TemplateData{Form: form})
+		return
+	}
+
+	recovery, err := e.checkSecondFactor(user, form.Get("code"))
+	if errors.Is(err, models.ErrInvalidCode) {
+		if err = e.loginFailed(r, user.Email); err != nil {
+			e.log.Err(err).Msgf("%s > record failed login", op)
+			e.er.ServerError(w, err)
+			return
+		}
+
+		form.Errors.Add("code", "The code is wrong or already used")
+		e.tmpl.Render(w, r, "twofactor.page.html", &template.TemplateData{Form: form})
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > check code", op)
+		e.releaseLogin(attempt)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err = e.mdl.Logins.Succeed(user.Email); err != nil {
+		e.log.Err(err).Msgf("%s > forget failed logins", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Remove(r, pendingUserID)
+	e.logIn(r, user)
+
+	if recovery {
+		left, err := e.mdl.RecoveryCodes.Remaining(user.ID)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > count recovery codes", op)
+			e.er.ServerError(w, err)
+			return
+		}
+		e.ses.Put(r, "flash", fmt.Sprintf("You logged in with a recovery code, %d left. Generate new ones on the account page if you lost your device.", left))
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Start two-factor enrollment with a new secret POST /user/2fa/setup
+func (e *Endpoint) TwoFactorSetupPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TwoFactorSetupPost()"
+
+	if template.AuthenticatedUser(r).TwoFactor() {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	// The secret is kept in the session until a code confirms it
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		e.log.Err(err).Msgf("%s > generate secret", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "totp-secret", secret)
+	http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+}
+
+// Two-factor enrollment page with the QR code GET /user/2fa/setup
+func (e *Endpoint) TwoFactorSetupGet(w http.ResponseWriter, r *http.Request) {
+	secret := e.ses.GetString(r, "totp-secret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	e.tmpl.Render(w, r, "twofactor_setup.page.html", &template.TemplateData{
+		UserName:   e.ses.GetString(r, template.UserName),
+		Flash:      e.ses.PopString(r, "flash"),
+		Form:       forms.New(nil),
+		TOTPSecret: secret,
+		TOTPURI:    totp.URI(serviceName, template.AuthenticatedUser(r).Email, secret),
+	})
+}
+
+// QR code of the secret being enrolled GET /user/2fa/qr.png
+func (e *Endpoint) TwoFactorQRGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TwoFactorQRGet()"
+
+	secret := e.ses.GetString(r, "totp-secret")
+	if secret == "" {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("no two-factor enrollment in progress"))
+		return
+	}
+
+	png, err := totp.QRCode(totp.URI(serviceName, template.AuthenticatedUser(r).Email, secret))
+	if err != nil {
+		e.log.Err(err).Msgf("%s > encode QR code", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// Finish enrollment with a code of the new secret POST /user/2fa/confirm
+func (e *Endpoint) TwoFactorConfirmPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TwoFactorConfirmPost()"
+
+	secret := e.ses.GetString(r, "totp-secret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("confirm two-factor POST /user/2fa/confirm error"))
+		return
+	}
+
+	step, ok := totp.Validate(secret, r.PostForm.Get("code"), time.Now())
+	if !ok {
+		e.ses.Put(r, "flash", "The code is wrong, check the time of your device and try again")
+		http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+		return
+	}
+
+	userID := template.AuthenticatedUser(r).ID
+
+	if err := e.mdl.Users.SetTOTP(userID, secret); err != nil {
+		e.log.Err(err).Msgf("%s > set secret", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	// The confirmation code can't be used to log in again
+	if err := e.mdl.Users.UseTOTPStep(userID, step); err != nil {
+		e.log.Err(err).Msgf("%s > use step", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err := e.newRecoveryCodes(r, userID); err != nil {
+		e.log.Err(err).Msgf("%s > create recovery codes", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Remove(r, "totp-secret")
+	e.ses.Put(r, "flash", "Two-factor authentication is on. Store the recovery codes in a safe place.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Turn two-factor authentication off with a current code POST /user/2fa/disable
+func (e *Endpoint) TwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TwoFactorDisablePost()"
+
+	user, ok := e.confirmCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := e.mdl.Users.SetTOTP(user.ID, ""); err != nil {
+		e.log.Err(err).Msgf("%s > remove secret", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	if err := e.mdl.RecoveryCodes.DeleteAll(user.ID); err != nil {
+		e.log.Err(err).Msgf("%s > delete recovery codes", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "Two-factor authentication is off")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Replace recovery codes with a current code POST /user/2fa/recovery
+func (e *Endpoint) TwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.TwoFactorRecoveryPost()"
+
+	user, ok := e.confirmCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := e.newRecoveryCodes(r, user.ID); err != nil {
+		e.log.Err(err).Msgf("%s > create recovery codes", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", "New recovery codes are generated, the old ones don't work anymore")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// Return the authenticated user with two-factor authentication if the code
+// form value is valid. Otherwise redirect to the account page and return
+// false. Wrong codes count as failed logins, so codes can't be guessed
+// here either.
+func (e *Endpoint) confirmCode(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	const op = "endpoint.confirmCode()"
+
+	user := template.AuthenticatedUser(r)
+	if !user.TwoFactor() {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return nil, false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		e.er.ClientError(w, http.StatusBadRequest, fmt.Errorf("two-factor code form error"))
+		return nil, false
+	}
+
+	attempt, refused, err := e.loginAttempt(r, user.Email)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > begin login attempt", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+	if refused != "" {
+		e.ses.Put(r, "flash", refused)
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return nil, false
+	}
+
+	_, err = e.checkSecondFactor(user, r.PostForm.Get("code"))
+	if errors.Is(err, models.ErrInvalidCode) {
+		if err = e.loginFailed(r, user.Email); err != nil {
+			e.log.Err(err).Msgf("%s > record failed login", op)
+			e.er.ServerError(w, err)
+			return nil, false
+		}
+
+		e.ses.Put(r, "flash", "The code is wrong or already used")
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > check code", op)
+		e.releaseLogin(attempt)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	if err = e.mdl.Logins.Succeed(user.Email); err != nil {
+		e.log.Err(err).Msgf("%s > forget failed logins", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// Check a one-time code or an unused recovery code of the user and use it
+// up. Report whether it was a recovery code, ErrInvalidCode is returned for
+// wrong codes.
+func (e *Endpoint) checkSecondFactor(user *models.User, code string) (bool, error) {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return false, e.mdl.Users.UseTOTPStep(user.ID, step)
+	}
+
+	code = totp.NormalizeRecoveryCode(code)
+	if code == "" {
+		return false, models.ErrInvalidCode
+	}
+
+	return true, e.mdl.RecoveryCodes.Use(user.ID, code)
+}
+
+// Replace recovery codes of the user and keep them in the session to show
+// them once on the account page
+func (e *Endpoint) newRecoveryCodes(r *http.Request, userID int) error {
+	codes, err := totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return err
+	}
+
+	if err = e.mdl.RecoveryCodes.Replace(userID, codes); err != nil {
+		return err
+	}
+
+	e.ses.Put(r, "recovery-codes", strings.Join(codes, " "))
+	return nil
+}
@@ -0,0 +1,172 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// If an uploaded file is larger than allowed.
+var errFileTooLarge = errors.New("file is too large")
+
+// Reader counting and hashing the bytes read through it. It fails with
+// errFileTooLarge as soon as more than limit bytes were read, so oversized
+// uploads are aborted by the storage instead of being stored completely.
+type hashingReader struct {
+	r     io.Reader
+	hash  hash.Hash
+	size  int64
+	limit int64
+}
+
+func newHashingReader(r io.Reader, limit int64) *hashingReader {
+	return &hashingReader{
+		r:     r,
+		hash:  sha256.New(),
+		limit: limit,
+	}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.size += int64(n)
+	h.hash.Write(p[:n])
+
+	if h.size > h.limit {
+		return n, errFileTooLarge
+	}
+
+	return n, err
+}
+
+// Return hex encoded SHA-256 of all read bytes
+func (h *hashingReader) Checksum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// Stream r into the storage and add it as file f, which must have name, type,
+// user and folder set. Size and checksum are computed on the fly. Return
+// errFileTooLarge if r has more than limit bytes and ErrQuotaExceeded if it
+// doesn't fit into the storage quota of the space. A full space is reported
+// before anything is stored.
+//
+// Contents are stored once per checksum: they are written under a temporary
+// key first and moved to the content addressed key only if no file with the
+// same checksum exists yet. Uploading to the name of an existing file adds a
+// new version of it and drops versions above the configured number.
+func (e *Endpoint) storeFile(ctx context.Context, f *models.File, r io.Reader, limit int64) (int, error) {
+	const op = "endpoint.storeFile()"
+
+	usage, err := e.spaceUsage(f.UserID, f.OrgID)
+	if err != nil {
+		return 0, err
+	}
+
+	quotaLimited := !usage.Unlimited() && usage.Left() < limit
+	if quotaLimited {
+		if usage.Left() == 0 {
+			return 0, models.ErrQuotaExceeded
+		}
+		limit = usage.Left()
+	}
+
+	// Checksum is unknown before all data is read, so write to a temporary
+	// key in the user's namespace.
+	tmpKey, err := storage.NewKey(f.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	hr := newHashingReader(r, limit)
+
+	// Storage drivers may wrap the reader error, so check the size itself.
+	err = e.str.Put(ctx, tmpKey, hr, -1)
+	if hr.size > limit {
+		e.str.Delete(ctx, tmpKey)
+		if quotaLimited {
+			return 0, models.ErrQuotaExceeded
+		}
+		return 0, errFileTooLarge
+	} else if err != nil {
+		return 0, err
+	}
+
+	f.Size = hr.size
+	f.Checksum = hr.Checksum()
+	f.StorageKey = storage.BlobKey(f.Checksum)
+
+	moved := false
+	id, err := e.mdl.Files.Insert(f, e.cfg.Files.Quota, func(created bool) error {
+		if !created {
+			return nil
+		}
+		moved = true
+		return e.str.Move(ctx, tmpKey, f.StorageKey)
+	})
+	if err != nil {
+		e.str.Delete(ctx, tmpKey)
+		return 0, err
+	}
+
+	// The file refers to the blob stored before, drop the duplicated contents
+	if !moved {
+		if err = e.str.Delete(ctx, tmpKey); err != nil {
+			e.log.Err(err).Msgf("%s > delete duplicated contents %s", op, tmpKey)
+		}
+	}
+
+	keep, err := e.maxVersions(f)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get version limit of file %d", op, id)
+		return id, nil
+	}
+
+	// The file is stored already, extra versions are dropped on the next upload otherwise.
+	err = e.mdl.Files.PruneVersions(id, keep, func(storageKey string) error {
+		return e.str.Delete(ctx, storageKey)
+	})
+	if err != nil {
+		e.log.Err(err).Msgf("%s > prune versions of file %d", op, id)
+	}
+
+	return id, nil
+}
+
+// Return how many prior versions of the file are kept: the limit of the
+// owner of the personal space up to the configured one
+func (e *Endpoint) maxVersions(f *models.File) (int, error) {
+	keep := e.cfg.Files.MaxVersions
+	if f.OrgID != 0 {
+		return keep, nil
+	}
+
+	owner, err := e.mdl.Users.Get(f.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	if owner.MaxVersions != models.DefaultMaxVersions && owner.MaxVersions < keep {
+		keep = owner.MaxVersions
+	}
+
+	return keep, nil
+}
+
+// Return storage usage of the user's personal space or of the organization.
+// Spaces without own quota have the configured one.
+func (e *Endpoint) spaceUsage(userID, orgID int) (*models.Usage, error) {
+	return e.mdl.Quotas.Usage(userID, orgID, e.cfg.Files.Quota)
+}
+
+// Return message telling that the space is full
+func quotaMessage(u *models.Usage) string {
+	return fmt.Sprintf("Not enough storage: %s of %s used", template.HumanSize(u.Used), template.HumanSize(u.Quota))
+}
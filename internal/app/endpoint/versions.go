@@ -0,0 +1,142 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// File page with version history GET /files/:id
+func (e *Endpoint) FileGet(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FileGet()"
+
+	f, ok := e.urlFile(w, r, models.Viewer)
+	if !ok {
+		return
+	}
+
+	versions, err := e.mdl.Files.Versions(f.ID)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get file versions from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	perm, err := e.filePermission(r, f)
+	if err != nil {
+		e.log.Err(err).Msgf("%s > get file permission", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	var grants []*models.Grant
+	if perm == models.Owner {
+		grants, err = e.mdl.Grants.File(f.ID)
+		if err != nil {
+			e.log.Err(err).Msgf("%s > get file grants from DB", op)
+			e.er.ServerError(w, err)
+			return
+		}
+	}
+
+	var uploader string
+	user, err := e.mdl.Users.Get(f.UploaderID)
+	if err == nil {
+		uploader = user.Name
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		e.log.Err(err).Msgf("%s > get uploader from DB", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.tmpl.Render(w, r, "file.page.html", &template.TemplateData{
+		UserName:   e.ses.GetString(r, template.UserName),
+		Flash:      e.ses.PopString(r, "flash"),
+		File:       f,
+		Versions:   versions,
+		Uploader:   uploader,
+		Permission: perm,
+		Grants:     grants,
+	})
+}
+
+// Download prior version of file GET /files/:id/versions/:version/download
+func (e *Endpoint) FileVersionDownloadGet(w http.ResponseWriter, r *http.Request) {
+	f, ok := e.urlFile(w, r, models.Viewer)
+	if !ok {
+		return
+	}
+
+	v, ok := e.urlVersion(w, r, f)
+	if !ok {
+		return
+	}
+
+	e.serveFile(w, r, &models.File{
+		ID:         f.ID,
+		Name:       f.Name,
+		Type:       v.Type,
+		Size:       v.Size,
+		Checksum:   v.Checksum,
+		Created:    v.Created,
+		StorageKey: v.StorageKey,
+		UserID:     f.UserID,
+		FolderID:   f.FolderID,
+	})
+}
+
+// Make prior version of file current POST /files/:id/versions/:version/restore
+func (e *Endpoint) FileVersionRestorePost(w http.ResponseWriter, r *http.Request) {
+	const op = "endpoint.FileVersionRestorePost()"
+
+	f, ok := e.urlFile(w, r, models.Editor)
+	if !ok {
+		return
+	}
+
+	v, ok := e.urlVersion(w, r, f)
+	if !ok {
+		return
+	}
+
+	err := e.mdl.Files.RestoreVersion(f.ID, v.ID)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > restore file version", op)
+		e.er.ServerError(w, err)
+		return
+	}
+
+	e.ses.Put(r, "flash", fmt.Sprintf("Version of %s restored", template.HumanDate(v.Created)))
+	http.Redirect(w, r, fmt.Sprintf("/files/%d", f.ID), http.StatusSeeOther)
+}
+
+// Return prior version of the file from :version URL parameter. Otherwise
+// write the error response and return false.
+func (e *Endpoint) urlVersion(w http.ResponseWriter, r *http.Request, f *models.File) (*models.FileVersion, bool) {
+	const op = "endpoint.urlVersion()"
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":version"))
+	if err != nil || id < 1 {
+		e.er.ClientError(w, http.StatusNotFound, fmt.Errorf("invalid version id"))
+		return nil, false
+	}
+
+	v, err := e.mdl.Files.Version(f.ID, id)
+	if errors.Is(err, models.ErrNoRecord) {
+		e.er.ClientError(w, http.StatusNotFound, err)
+		return nil, false
+	} else if err != nil {
+		e.log.Err(err).Msgf("%s > get file version from DB", op)
+		e.er.ServerError(w, err)
+		return nil, false
+	}
+
+	return v, true
+}
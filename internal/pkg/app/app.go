@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
@@ -15,11 +16,14 @@ import (
 	"time"
 
 	"github.com/alekslesik/file-cloud/internal/app/endpoint"
+	"github.com/alekslesik/file-cloud/internal/pkg/auth"
+	"github.com/alekslesik/file-cloud/internal/pkg/janitor"
 	"github.com/alekslesik/file-cloud/internal/pkg/mailer"
 	"github.com/alekslesik/file-cloud/internal/pkg/middleware"
 	"github.com/alekslesik/file-cloud/internal/pkg/model"
 	"github.com/alekslesik/file-cloud/internal/pkg/router"
 	"github.com/alekslesik/file-cloud/internal/pkg/session"
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
 	tmpl "github.com/alekslesik/file-cloud/internal/pkg/template"
 	"github.com/alekslesik/file-cloud/pkg/config"
 	"github.com/alekslesik/file-cloud/pkg/logging"
@@ -36,6 +40,9 @@ type Application struct {
 	template   *tmpl.Template
 	dataBase   *sql.DB
 	mailer     *mailer.Mailer
+	storage    storage.Backend
+	auth       auth.Authenticator
+	janitor    *janitor.Janitor
 }
 
 // Create new instance of application
@@ -79,13 +86,37 @@ func (a *Application) Run() error {
 	// helpers := initHelpers(logger)
 	csErrors := initCSError()
 
+	a.storage, err = initStorage(a.config)
+	if err != nil {
+		a.logger.Err(err).Msgf("%s > open storage", op)
+		return err
+	}
+
 	a.session = initSession(a.config)
 	a.model = initModel(dataBase)
-	a.middleware = initMiddleware(a.session, a.logger, csErrors, a.model)
+
+	a.auth, err = initAuth(a.config, a.model)
+	if err != nil {
+		a.logger.Err(err).Msgf("%s > open authenticators", op)
+		return err
+	}
+
+	a.middleware, err = initMiddleware(a.session, a.logger, csErrors, a.model, a.config)
+	if err != nil {
+		a.logger.Err(err).Msgf("%s > init middleware", op)
+		return err
+	}
+
 	a.template = initTemplate(a.logger)
 	a.mailer = initMailer(a.config)
-	a.endpoint = initEndpoint(*a.template, a.logger, csErrors, a.model, a.session, a.mailer)
+	a.endpoint = initEndpoint(*a.template, a.logger, csErrors, a.model, a.session, a.mailer, a.storage, a.auth, a.config)
 	a.router = initRouter(a.endpoint, a.middleware, a.session)
+	a.janitor = initJanitor(a.logger, a.model, a.storage, a.config)
+
+	// Run background cleanup until the server is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.janitor.Run(ctx)
 
 	var serverErr error
 
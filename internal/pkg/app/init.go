@@ -5,12 +5,15 @@ import (
 	"os"
 
 	"github.com/alekslesik/file-cloud/internal/app/endpoint"
+	"github.com/alekslesik/file-cloud/internal/pkg/auth"
 	"github.com/alekslesik/file-cloud/internal/pkg/cserror"
+	"github.com/alekslesik/file-cloud/internal/pkg/janitor"
 	"github.com/alekslesik/file-cloud/internal/pkg/mailer"
 	"github.com/alekslesik/file-cloud/internal/pkg/middleware"
 	"github.com/alekslesik/file-cloud/internal/pkg/model"
 	"github.com/alekslesik/file-cloud/internal/pkg/router"
 	"github.com/alekslesik/file-cloud/internal/pkg/session"
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
 	tmpl "github.com/alekslesik/file-cloud/internal/pkg/template"
 	"github.com/alekslesik/file-cloud/pkg/config"
 	"github.com/alekslesik/file-cloud/pkg/logging"
@@ -71,19 +74,29 @@ func initDB(cfg *config.Config) (*sql.DB, error) {
 	return db, err
 }
 
+// Storage backend initialization
+func initStorage(cfg *config.Config) (storage.Backend, error) {
+	return storage.Open(cfg.Storage)
+}
+
 // Declare an instance of the config struct
 func initModel(db *sql.DB) *model.Model {
 	return model.New(db)
 }
 
+// Chain of the configured password checks
+func initAuth(cfg *config.Config, model *model.Model) (auth.Authenticator, error) {
+	return auth.Open(cfg.Auth, model.Users, model.Users)
+}
+
 // Declare an instance of the config struct
-func initMiddleware(session *session.Session, logger *logging.Logger, CSError *cserror.CSError, model *model.Model) *middleware.Middleware {
-	return middleware.New(session, logger, CSError, model)
+func initMiddleware(session *session.Session, logger *logging.Logger, CSError *cserror.CSError, model *model.Model, cfg *config.Config) (*middleware.Middleware, error) {
+	return middleware.New(session, logger, CSError, model, cfg)
 }
 
 // Declare an instance of the config struct
-func initEndpoint(template tmpl.Template, logger *logging.Logger, CSError *cserror.CSError, model *model.Model, session *session.Session, mlr *mailer.Mailer) *endpoint.Endpoint {
-	return endpoint.New(&template, logger, CSError, model, *session, mlr)
+func initEndpoint(template tmpl.Template, logger *logging.Logger, CSError *cserror.CSError, model *model.Model, session *session.Session, mlr *mailer.Mailer, str storage.Backend, ath auth.Authenticator, cfg *config.Config) *endpoint.Endpoint {
+	return endpoint.New(&template, logger, CSError, model, *session, mlr, str, ath, cfg)
 }
 
 // Declare an instance of the config struct
@@ -104,3 +117,8 @@ func initTemplate(logger *logging.Logger) *tmpl.Template {
 func initMailer(cfg *config.Config) *mailer.Mailer {
 	return mailer.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender)
 }
+
+// Declare an instance of the janitor struct
+func initJanitor(logger *logging.Logger, model *model.Model, str storage.Backend, cfg *config.Config) *janitor.Janitor {
+	return janitor.New(logger, model, str, cfg.Files.CleanupInterval, cfg.Files.TrashRetention, cfg.Login.Window)
+}
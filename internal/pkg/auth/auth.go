@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+const (
+	LOCAL = "local"
+	LDAP  = "ldap"
+)
+
+var ErrNoBackend = errors.New("auth: backend not supported")
+
+// Authenticator is implemented by every password check of the login. The
+// local users table is one, so the users model is used as it is.
+type Authenticator interface {
+	// Authenticate returns the ID and name of the user with the email and
+	// password. ErrInvalidCredentials is returned for unknown users and wrong
+	// passwords.
+	Authenticate(email, password string) (int, string, error)
+}
+
+// Chain asks its authenticators in order until one knows the credentials
+type Chain []Authenticator
+
+// Return the user of the first authenticator accepting the credentials.
+// ErrNotActivated stops the chain. Once an authenticator rejected the
+// credentials, errors of the others, e.g. an unreachable directory, come
+// joined with ErrInvalidCredentials, so the login counts as failed and local
+// passwords can't be guessed while the directory is down. Otherwise the first
+// error is returned.
+func (c Chain) Authenticate(email, password string) (int, string, error) {
+	var failure error
+	rejected := false
+
+	for _, a := range c {
+		id, name, err := a.Authenticate(email, password)
+		if err == nil {
+			return id, name, nil
+		} else if errors.Is(err, models.ErrInvalidCredentials) {
+			rejected = true
+			continue
+		} else if errors.Is(err, models.ErrNotActivated) {
+			return 0, "", err
+		}
+
+		if failure == nil {
+			failure = err
+		}
+	}
+
+	if rejected && failure != nil {
+		return 0, "", errors.Join(models.ErrInvalidCredentials, failure)
+	} else if failure != nil {
+		return 0, "", failure
+	}
+
+	return 0, "", models.ErrInvalidCredentials
+}
+
+// Open the chain of the configured backends. Local is the users table.
+func Open(cfg config.AuthConfig, local Authenticator, users LDAPUsers) (Chain, error) {
+	var chain Chain
+
+	for _, backend := range cfg.Backends {
+		switch strings.TrimSpace(backend) {
+		case LOCAL:
+			chain = append(chain, local)
+		case LDAP:
+			l, err := NewLDAP(cfg.LDAP, users)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, l)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrNoBackend, backend)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, ErrNoBackend
+	}
+
+	return chain, nil
+}
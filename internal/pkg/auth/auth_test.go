@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Authenticator returning a fixed result
+type fixed struct {
+	id  int
+	err error
+}
+
+func (f fixed) Authenticate(email, password string) (int, string, error) {
+	if f.err != nil {
+		return 0, "", f.err
+	}
+
+	return f.id, "user", nil
+}
+
+func TestChainAuthenticate(t *testing.T) {
+	errDown := errors.New("auth: LDAP dial: connection refused")
+
+	accept := fixed{id: 1}
+	acceptOther := fixed{id: 2}
+	reject := fixed{err: models.ErrInvalidCredentials}
+	down := fixed{err: errDown}
+	inactive := fixed{err: models.ErrNotActivated}
+
+	tests := []struct {
+		name    string
+		chain   Chain
+		wantID  int
+		wantErr []error
+		failed  bool
+	}{
+		{"first accepts", Chain{accept, down}, 1, nil, false},
+		{"second accepts after rejection", Chain{reject, acceptOther}, 2, nil, false},
+		{"second accepts after error", Chain{down, acceptOther}, 2, nil, false},
+		{"all reject", Chain{reject, reject}, 0, []error{models.ErrInvalidCredentials}, true},
+		{"rejected then error", Chain{reject, down}, 0, []error{models.ErrInvalidCredentials, errDown}, true},
+		{"error then rejected", Chain{down, reject}, 0, []error{models.ErrInvalidCredentials, errDown}, true},
+		{"only errors", Chain{down}, 0, []error{errDown}, false},
+		{"not activated stops", Chain{inactive, acceptOther}, 0, []error{models.ErrNotActivated}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, err := tt.chain.Authenticate("alice@example.com", "secret")
+
+			if id != tt.wantID {
+				t.Errorf("got ID %d; want %d", id, tt.wantID)
+			}
+
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("got error %v; want none", err)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("got error %v; want %v", err, want)
+				}
+			}
+
+			// Only rejected credentials count as failed logins
+			rejected := errors.Is(err, models.ErrInvalidCredentials)
+			if rejected != tt.failed {
+				t.Errorf("got failed login %t; want %t", rejected, tt.failed)
+			}
+		})
+	}
+}
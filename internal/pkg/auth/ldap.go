@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Users of the directory, created in the users table on their first login
+type LDAPUsers interface {
+	SyncLDAP(dn, name, email string, admin bool) (int, error)
+}
+
+// LDAPAuthenticator checks passwords by binding to the directory as the user.
+// The user is searched by the login first and mapped to a role by its groups.
+type LDAPAuthenticator struct {
+	cfg         config.LDAPConfig
+	tls         *tls.Config
+	userGroups  []*ldap.DN
+	adminGroups []*ldap.DN
+	users       LDAPUsers
+}
+
+// Return LDAPAuthenticator of the directory in the config
+func NewLDAP(cfg config.LDAPConfig, users LDAPUsers) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("auth: LDAP URL: %w", err)
+	}
+
+	if cfg.BaseDN == "" {
+		return nil, errors.New("auth: LDAP base DN is not set")
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, errors.New("auth: LDAP user filter has no %s for the login")
+	}
+
+	l := &LDAPAuthenticator{
+		cfg:   cfg,
+		tls:   &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+		users: users,
+	}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("auth: LDAP CA certificate: %w", err)
+		}
+
+		l.tls.RootCAs = x509.NewCertPool()
+		if !l.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("auth: no certificates in %s", cfg.CACert)
+		}
+	}
+
+	if l.userGroups, err = parseDNs(cfg.UserGroups); err != nil {
+		return nil, err
+	}
+	if l.adminGroups, err = parseDNs(cfg.AdminGroups); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Return the ID and name of the user with the login and password, creating
+// the user in the users table on the first login
+func (l *LDAPAuthenticator) Authenticate(login, password string) (int, string, error) {
+	// The directory treats a bind without password as anonymous and
+	// accepts it
+	if login == "" || password == "" {
+		return 0, "", models.ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+
+	if l.cfg.BindDN != "" {
+		if err = conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			return 0, "", fmt.Errorf("auth: LDAP bind as %s: %w", l.cfg.BindDN, err)
+		}
+	}
+
+	entry, err := l.searchUser(conn, login)
+	if err != nil {
+		return 0, "", err
+	}
+
+	groups, err := l.groups(conn, entry)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return 0, "", models.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", fmt.Errorf("auth: LDAP bind as %s: %w", entry.DN, err)
+	}
+
+	admin := memberOf(groups, l.adminGroups)
+	if len(l.userGroups) > 0 && !admin && !memberOf(groups, l.userGroups) {
+		return 0, "", models.ErrInvalidCredentials
+	}
+
+	email := entry.GetAttributeValue(l.cfg.EmailAttribute)
+	if email == "" {
+		return 0, "", fmt.Errorf("auth: LDAP entry %s has no %s", entry.DN, l.cfg.EmailAttribute)
+	}
+
+	name := entry.GetAttributeValue(l.cfg.NameAttribute)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	id, err := l.users.SyncLDAP(entry.DN, name, email, admin)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, name, nil
+}
+
+// Connect to the directory, over TLS if configured
+func (l *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.cfg.Timeout}),
+		ldap.DialWithTLSConfig(l.tls))
+	if err != nil {
+		return nil, fmt.Errorf("auth: LDAP dial: %w", err)
+	}
+	conn.SetTimeout(l.cfg.Timeout)
+
+	if l.cfg.StartTLS {
+		if err = conn.StartTLS(l.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("auth: LDAP StartTLS: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// Return the only entry matching the login. ErrInvalidCredentials is
+// returned if there is none.
+func (l *LDAPAuthenticator) searchUser(conn *ldap.Conn, login string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(l.cfg.UserFilter, "%s", ldap.EscapeFilter(login))
+
+	req := ldap.NewSearchRequest(l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.cfg.Timeout.Seconds()), false, filter,
+		[]string{l.cfg.EmailAttribute, l.cfg.NameAttribute, "memberOf"}, nil)
+
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(res.Entries) > 1) {
+		return nil, fmt.Errorf("auth: LDAP user filter matches several entries for %q", login)
+	} else if err != nil {
+		return nil, fmt.Errorf("auth: LDAP search user: %w", err)
+	}
+
+	if len(res.Entries) == 0 {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	return res.Entries[0], nil
+}
+
+// Return DNs of the groups of the user entry, searched under the group base
+// DN or read from its memberOf attribute
+func (l *LDAPAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]*ldap.DN, error) {
+	dns := entry.GetAttributeValues("memberOf")
+
+	if l.cfg.GroupBaseDN != "" {
+		filter := strings.ReplaceAll(l.cfg.GroupFilter, "%s", ldap.EscapeFilter(entry.DN))
+
+		// 1.1 asks for no attributes, only DNs are needed
+		req := ldap.NewSearchRequest(l.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, int(l.cfg.Timeout.Seconds()), false, filter, []string{"1.1"}, nil)
+
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("auth: LDAP search groups: %w", err)
+		}
+
+		dns = make([]string, 0, len(res.Entries))
+		for _, e := range res.Entries {
+			dns = append(dns, e.DN)
+		}
+	}
+
+	groups := make([]*ldap.DN, 0, len(dns))
+	for _, s := range dns {
+		if dn, err := ldap.ParseDN(s); err == nil {
+			groups = append(groups, dn)
+		}
+	}
+
+	return groups, nil
+}
+
+// Report whether any of the groups is one of the wanted ones
+func memberOf(groups, wanted []*ldap.DN) bool {
+	for _, g := range groups {
+		for _, w := range wanted {
+			if g.EqualFold(w) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func parseDNs(values []string) ([]*ldap.DN, error) {
+	dns := make([]*ldap.DN, 0, len(values))
+
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		dn, err := ldap.ParseDN(v)
+		if err != nil {
+			return nil, fmt.Errorf("auth: group DN %q: %w", v, err)
+		}
+		dns = append(dns, dn)
+	}
+
+	return dns, nil
+}
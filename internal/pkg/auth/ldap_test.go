@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Users synced from the directory, kept in memory
+type syncedUsers struct {
+	synced map[string]syncedUser
+}
+
+type syncedUser struct {
+	id    int
+	name  string
+	email string
+	admin bool
+}
+
+func (s *syncedUsers) SyncLDAP(dn, name, email string, admin bool) (int, error) {
+	u, ok := s.synced[dn]
+	if !ok {
+		u.id = len(s.synced) + 1
+	}
+	u.name, u.email, u.admin = name, email, admin
+	s.synced[dn] = u
+
+	return u.id, nil
+}
+
+// Return the value of the environment variable or def if it's not set
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Add users and groups under a new organizational unit of the OpenLDAP
+// server at LDAP_TEST_URL, e.g. the one of the README, and return the config
+// of the directory. The test is skipped without it.
+func testDirectory(t *testing.T) config.LDAPConfig {
+	t.Helper()
+
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL is not set")
+	}
+
+	bindDN := getenv("LDAP_TEST_BIND_DN", "cn=admin,dc=example,dc=com")
+	bindPassword := getenv("LDAP_TEST_BIND_PASSWORD", "admin")
+	baseDN := getenv("LDAP_TEST_BASE_DN", "dc=example,dc=com")
+
+	conn, err := ldap.DialURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err = conn.Bind(bindDN, bindPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	name := "test-" + hex.EncodeToString(b)
+	ou := "ou=" + name + "," + baseDN
+
+	var added []string
+	add := func(dn string, attrs map[string][]string) {
+		req := ldap.NewAddRequest(dn, nil)
+		for k, v := range attrs {
+			req.Attribute(k, v)
+		}
+		if err := conn.Add(req); err != nil {
+			t.Fatalf("add %s: %v", dn, err)
+		}
+		added = append(added, dn)
+	}
+
+	// Entries are deleted children first
+	t.Cleanup(func() {
+		for i := len(added) - 1; i >= 0; i-- {
+			conn.Del(ldap.NewDelRequest(added[i], nil))
+		}
+	})
+
+	person := func(uid, name string) map[string][]string {
+		return map[string][]string{
+			"objectClass":  {"inetOrgPerson"},
+			"uid":          {uid},
+			"cn":           {name},
+			"sn":           {name},
+			"mail":         {uid + "@example.com"},
+			"userPassword": {"secret-" + uid},
+		}
+	}
+
+	add(ou, map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {name}})
+	add("uid=bob,"+ou, person("bob", "Bob"))
+	add("uid=alice,"+ou, person("alice", "Alice"))
+	add("uid=eve,"+ou, person("eve", "Eve"))
+	add("cn=file-cloud,"+ou, map[string][]string{"objectClass": {"groupOfNames"}, "cn": {"file-cloud"},
+		"member": {"uid=bob," + ou}})
+	add("cn=admins,"+ou, map[string][]string{"objectClass": {"groupOfNames"}, "cn": {"admins"},
+		"member": {"uid=alice," + ou}})
+
+	return config.LDAPConfig{
+		URL:            url,
+		BindDN:         bindDN,
+		BindPassword:   bindPassword,
+		BaseDN:         ou,
+		UserFilter:     "(&(objectClass=person)(mail=%s))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		GroupBaseDN:    ou,
+		GroupFilter:    "(|(member=%s)(uniqueMember=%s))",
+		UserGroups:     []string{"cn=file-cloud," + ou},
+		AdminGroups:    []string{"cn=admins," + ou},
+		Timeout:        5 * time.Second,
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	cfg := testDirectory(t)
+
+	users := &syncedUsers{synced: map[string]syncedUser{}}
+
+	l, err := NewLDAP(cfg, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		login     string
+		password  string
+		wantErr   error
+		wantName  string
+		wantAdmin bool
+	}{
+		{"user group member", "bob@example.com", "secret-bob", nil, "Bob", false},
+		{"admin group member", "alice@example.com", "secret-alice", nil, "Alice", true},
+		{"wrong password", "bob@example.com", "wrong", models.ErrInvalidCredentials, "", false},
+		{"empty password", "bob@example.com", "", models.ErrInvalidCredentials, "", false},
+		{"unknown user", "nobody@example.com", "secret-bob", models.ErrInvalidCredentials, "", false},
+		{"filter injection", "*", "secret-bob", models.ErrInvalidCredentials, "", false},
+		{"no group", "eve@example.com", "secret-eve", models.ErrInvalidCredentials, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, name, err := l.Authenticate(tt.login, tt.password)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if name != tt.wantName {
+				t.Errorf("got name %q; want %q", name, tt.wantName)
+			}
+
+			var synced *syncedUser
+			for _, u := range users.synced {
+				if u.id == id {
+					u := u
+					synced = &u
+				}
+			}
+
+			if synced == nil || synced.email != tt.login || synced.admin != tt.wantAdmin {
+				t.Errorf("got synced user %+v; want %s with admin %t", synced, tt.login, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+// Logins fail with an error other than ErrInvalidCredentials when the
+// directory can't be reached, so they don't count as failed logins
+func TestLDAPUnreachable(t *testing.T) {
+	l, err := NewLDAP(config.LDAPConfig{
+		URL:        "ldap://127.0.0.1:1",
+		BaseDN:     "dc=example,dc=com",
+		UserFilter: "(mail=%s)",
+		Timeout:    time.Second,
+	}, &syncedUsers{synced: map[string]syncedUser{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = l.Authenticate("bob@example.com", "secret")
+	if err == nil || errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("got error %v; want a dial error", err)
+	}
+}
@@ -1,6 +1,9 @@
 package cserror
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+)
 
 type CSError struct {
 }
@@ -25,3 +28,34 @@ func (e *CSError) ServerError(w http.ResponseWriter, err error) {
 
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
+
+// Error body of API responses. Fields holds messages of invalid request
+// fields by their names.
+type apiError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// The APIError helper sends the status code with a JSON body describing the
+// problem to API clients.
+func (e *CSError) APIError(w http.ResponseWriter, status int, message string) {
+	writeAPIError(w, status, apiError{Error: message})
+}
+
+// The APIFailedValidation helper sends 422 Unprocessable Entity with messages
+// of the invalid fields.
+func (e *CSError) APIFailedValidation(w http.ResponseWriter, fields map[string]string) {
+	writeAPIError(w, http.StatusUnprocessableEntity, apiError{Error: "request validation failed", Fields: fields})
+}
+
+// The APIServerError helper sends a generic 500 Internal Server Error body to
+// API clients.
+func (e *CSError) APIServerError(w http.ResponseWriter, err error) {
+	e.APIError(w, http.StatusInternalServerError, "the server encountered a problem and could not process the request")
+}
+
+func writeAPIError(w http.ResponseWriter, status int, body apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
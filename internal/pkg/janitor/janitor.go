@@ -0,0 +1,117 @@
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/model"
+	"github.com/alekslesik/file-cloud/internal/pkg/storage"
+	"github.com/alekslesik/file-cloud/pkg/logging"
+)
+
+// Janitor periodically removes expired data from the database and the storage
+type Janitor struct {
+	log      *logging.Logger
+	mdl      *model.Model
+	str      storage.Backend
+	interval time.Duration
+	// How long deleted files are kept in the trash
+	retention time.Duration
+	// How long failed logins are counted
+	loginWindow time.Duration
+}
+
+func New(log *logging.Logger, mdl *model.Model, str storage.Backend, interval, retention, loginWindow time.Duration) *Janitor {
+	return &Janitor{
+		log:         log,
+		mdl:         mdl,
+		str:         str,
+		interval:    interval,
+		retention:   retention,
+		loginWindow: loginWindow,
+	}
+}
+
+// Run cleanup every interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Janitor) cleanup(ctx context.Context) {
+	const op = "janitor.cleanup()"
+
+	if err := j.purgeUploads(ctx); err != nil {
+		j.log.Err(err).Msgf("%s > purge expired uploads", op)
+	}
+
+	if err := j.purgeTrash(ctx); err != nil {
+		j.log.Err(err).Msgf("%s > purge trash", op)
+	}
+
+	// Failed logins only count within the window
+	if err := j.mdl.Logins.DeleteBefore(time.Now().Add(-j.loginWindow)); err != nil {
+		j.log.Err(err).Msgf("%s > purge failed logins", op)
+	}
+}
+
+// Remove expired resumable uploads. Finished ones are kept until then, so
+// clients can still ask for their offset.
+func (j *Janitor) purgeUploads(ctx context.Context) error {
+	uploads, err := j.mdl.Uploads.Expired(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, u := range uploads {
+		parts, err := j.mdl.Uploads.Parts(u.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range parts {
+			if err = j.str.Delete(ctx, p.StorageKey); err != nil {
+				return err
+			}
+		}
+
+		if err = j.mdl.Uploads.Delete(u.ID); err != nil {
+			return err
+		}
+
+		j.log.Info().Msgf("expired upload %s of user %d removed", u.ID, u.UserID)
+	}
+
+	return nil
+}
+
+// Permanently delete files kept in the trash longer than the retention period
+func (j *Janitor) purgeTrash(ctx context.Context) error {
+	files, err := j.mdl.Files.TrashedBefore(time.Now().Add(-j.retention))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		err = j.mdl.Files.Delete(f.ID, func(storageKey string) error {
+			return j.str.Delete(ctx, storageKey)
+		})
+		if err != nil {
+			return err
+		}
+
+		j.log.Info().Msgf("trashed file %d of user %d purged", f.ID, f.UserID)
+	}
+
+	return nil
+}
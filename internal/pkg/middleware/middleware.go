@@ -1,16 +1,24 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/alekslesik/file-cloud/internal/pkg/cserror"
 	"github.com/alekslesik/file-cloud/internal/pkg/model"
+	"github.com/alekslesik/file-cloud/internal/pkg/ratelimit"
 	"github.com/alekslesik/file-cloud/internal/pkg/session"
 	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/config"
 	"github.com/alekslesik/file-cloud/pkg/logging"
 	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
 )
 
@@ -19,15 +27,30 @@ type Middleware struct {
 	log *logging.Logger
 	er  *cserror.CSError
 	mdl *model.Model
+	cfg *config.Config
+	// Networks of trusted reverse proxies
+	proxies []*net.IPNet
+	// Token buckets of the rate limit policies
+	limiters map[string]*ratelimit.Limiter
 }
 
-func New(ss *session.Session, lg *logging.Logger, er *cserror.CSError, md *model.Model) *Middleware {
-	return &Middleware{
-		ses: ss,
-		log: lg,
-		er:  er,
-		mdl: md,
+// Return Middleware or an error if the trusted proxies in the config are
+// invalid, since clients would be seen as the proxy's address otherwise
+func New(ss *session.Session, lg *logging.Logger, er *cserror.CSError, md *model.Model, cfg *config.Config) (*Middleware, error) {
+	proxies, err := parseProxies(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
 	}
+
+	return &Middleware{
+		ses:      ss,
+		log:      lg,
+		er:       er,
+		mdl:      md,
+		cfg:      cfg,
+		proxies:  proxies,
+		limiters: newLimiters(cfg.RateLimit),
+	}, nil
 }
 
 func (m *Middleware) SecureHeaders(next http.Handler) http.Handler {
@@ -52,6 +75,90 @@ func (m *Middleware) NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
+// Same as NoSurf, but leaves checking of the token to the handler. Looking for
+// the token in a multipart body would read the whole body before the handler
+// is called, so handlers streaming the body must verify the token themselves.
+func (m *Middleware) NoSurfStream(next http.Handler) http.Handler {
+	csrfHandler := nosurf.New(next)
+	csrfHandler.SetBaseCookie(http.Cookie{
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   true,
+	})
+	csrfHandler.ExemptFunc(func(r *http.Request) bool { return true })
+
+	return csrfHandler
+}
+
+// Extend read and write deadlines of the connection for routes transferring
+// file contents, which can't be done within the server wide timeouts. Must be
+// used before any middleware wrapping the http.ResponseWriter.
+func (m *Middleware) ExtendDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := "middleware.ExtendDeadlines()"
+
+		rc := http.NewResponseController(w)
+		deadline := time.Now().Add(m.cfg.Files.TransferTimeout)
+
+		if err := rc.SetReadDeadline(deadline); err != nil {
+			m.log.Err(err).Msgf("%s > set read deadline", op)
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil {
+			m.log.Err(err).Msgf("%s > set write deadline", op)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Return middleware running chain of session middleware without letting the
+// session buffer the whole response in memory. The chain loads the session
+// and authenticates the user, then next writes straight to the connection.
+// If the chain responds by itself (e.g. redirects to login), that response is
+// sent instead. Changes of the session made by next are not saved.
+func (m *Middleware) Unbuffered(chain alice.Chain) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var passed *http.Request
+
+			cw := &capturingWriter{ResponseWriter: w}
+			chain.ThenFunc(func(_ http.ResponseWriter, r *http.Request) {
+				passed = r
+			}).ServeHTTP(cw, r)
+
+			if passed == nil {
+				cw.flush()
+				return
+			}
+
+			next.ServeHTTP(w, passed)
+		})
+	}
+}
+
+// Writer holding back the response, but sharing headers with the underlying
+// writer, so cookies set by the session get into the final response.
+type capturingWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+}
+
+func (c *capturingWriter) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+func (c *capturingWriter) WriteHeader(code int) {
+	c.code = code
+}
+
+func (c *capturingWriter) flush() {
+	if c.code != 0 {
+		c.ResponseWriter.WriteHeader(c.code)
+	}
+	c.ResponseWriter.Write(c.buf.Bytes())
+}
+
 func (m *Middleware) LogRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		m.log.Info().Msgf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.RequestURI)
@@ -80,18 +187,30 @@ func (m *Middleware) RecoverPanic(next http.Handler) http.Handler {
 // If the user is not authenticated, redirect them to the login page and
 // return from the middleware chain so that no subsequent handlers in
 // the chain are executed.
-// func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		if app.authenticatedUser(r) == nil {
-// 			app.session.Put(r, "flash", "Please login")
-// 			http.Redirect(w, r, "/user/login", http.StatusFound)
-// 			return
-// 		}
-
-// 		// Otherwise call the next handler in the chain.
-// 		next.ServeHTTP(w, r)
-// 	})
-// }
+func (m *Middleware) RequireAuthenticatedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if template.AuthenticatedUser(r) == nil {
+			m.ses.Put(r, "flash", "Please login")
+			http.Redirect(w, r, "/user/login", http.StatusFound)
+			return
+		}
+
+		// Otherwise call the next handler in the chain.
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Administration pages don't exist for other users, including anonymous ones.
+func (m *Middleware) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := template.AuthenticatedUser(r); user == nil || !user.Admin {
+			m.er.ClientError(w, http.StatusNotFound, models.ErrNoRecord)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
 
 func (m *Middleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -117,6 +236,17 @@ func (m *Middleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Sessions started before the last password change are logged out
+		if m.ses.GetInt(r, template.SessionVersion) != user.SessionVersion {
+			m.ses.Remove(r, template.UserID)
+			m.ses.Remove(r, template.UserName)
+			m.ses.Remove(r, template.SessionVersion)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user.Admin = user.Admin || strings.EqualFold(user.Email, m.cfg.App.AdminUser.Email)
+
 		// Otherwise, we know that the request is coming from a valid,
 		// authenticated (logged in) user. We create a new copy of the
 		// request with the user information added to the request context, and
@@ -125,3 +255,92 @@ func (m *Middleware) Authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Key of the API token of the request in its context
+type contextKey string
+
+const tokenContextKey = contextKey("token")
+
+// Authenticate API requests by the bearer token in the Authorization header,
+// either created with the user's password or a personal access token.
+// Requests without the header stay anonymous, invalid or expired tokens are
+// rejected.
+func (m *Middleware) AuthenticateToken(next http.Handler) http.Handler {
+	const op = "middleware.AuthenticateToken()"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, plaintext, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || plaintext == "" {
+			m.invalidToken(w)
+			return
+		}
+
+		token, err := m.mdl.Tokens.Authenticate(strings.TrimSpace(plaintext))
+		if errors.Is(err, models.ErrNoRecord) {
+			m.invalidToken(w)
+			return
+		} else if err != nil {
+			m.log.Err(err).Msgf("%s > get token from DB", op)
+			m.er.APIServerError(w, err)
+			return
+		}
+
+		user, err := m.mdl.Users.Get(token.UserID)
+		if errors.Is(err, models.ErrNoRecord) {
+			m.invalidToken(w)
+			return
+		} else if err != nil {
+			m.log.Err(err).Msgf("%s > get user from DB", op)
+			m.er.APIServerError(w, err)
+			return
+		}
+
+		user.Admin = user.Admin || strings.EqualFold(user.Email, m.cfg.App.AdminUser.Email)
+
+		ctx := context.WithValue(r.Context(), template.UserID, user)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// API routes available to tokens with the scope only. Must be used after
+// RequireToken.
+func (m *Middleware) RequireScope(scope string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(tokenContextKey).(*models.Token)
+			if !ok || !token.Allows(scope) {
+				m.er.APIError(w, http.StatusForbidden, fmt.Sprintf("the token doesn't have the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// API routes available to clients with a valid token only.
+func (m *Middleware) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if template.AuthenticatedUser(r) == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			m.er.APIError(w, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) invalidToken(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	m.er.APIError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+}
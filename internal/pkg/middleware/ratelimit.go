@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/ratelimit"
+	"github.com/alekslesik/file-cloud/internal/pkg/template"
+	"github.com/alekslesik/file-cloud/pkg/config"
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"github.com/justinas/alice"
+)
+
+// Rate limit policies of routes
+const (
+	// Forms checking credentials or sending mails
+	PolicyAuth = "auth"
+	// Any page or API call
+	PolicyRequests = "requests"
+	// Bytes of uploaded request bodies
+	PolicyUpload = "upload"
+)
+
+// Return limiters of the policies in the config
+func newLimiters(cfg config.RateLimitConfig) map[string]*ratelimit.Limiter {
+	return map[string]*ratelimit.Limiter{
+		PolicyAuth:     ratelimit.New(cfg.AuthPerMinute/60, cfg.AuthBurst),
+		PolicyRequests: ratelimit.New(cfg.RequestsPerMinute/60, cfg.RequestBurst),
+		PolicyUpload:   ratelimit.New(cfg.UploadBytesPerSecond, cfg.UploadBurst),
+	}
+}
+
+// Parse IP addresses and CIDR ranges of trusted proxies
+func parseProxies(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", v)
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// Replace the remote address of requests coming through trusted proxies with
+// the client's address from X-Forwarded-For or X-Real-IP, so logs, login
+// protection and rate limits see the client. Headers of other peers are
+// ignored, clients could fake them.
+func (m *Middleware) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.proxies) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		peer := net.ParseIP(host)
+		if peer == nil || !m.trusted(peer) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ip := m.forwardedFor(r); ip != nil {
+			r.RemoteAddr = ip.String()
+		} else if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			r.RemoteAddr = ip.String()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Return the client's address in X-Forwarded-For, the rightmost one not of a
+// trusted proxy. Every proxy appends the address it got the request from, so
+// addresses left of it could be faked by the client.
+func (m *Middleware) forwardedFor(r *http.Request) net.IP {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+
+		client = ip
+		if !m.trusted(ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+// Report whether the address is one of a trusted proxy
+func (m *Middleware) trusted(ip net.IP) bool {
+	for _, n := range m.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Limit requests of the route by the policy, counted per API token,
+// authenticated user or client IP address. Requests over the limit get 429
+// Too Many Requests with Retry-After. The upload policy counts bytes of the
+// request body instead and slows down reading it once the burst is used up.
+// Must be used after Authenticate or AuthenticateToken to count by the user.
+func (m *Middleware) RateLimit(policy string) alice.Constructor {
+	limiter, ok := m.limiters[policy]
+	if !ok {
+		panic(fmt.Sprintf("middleware: unknown rate limit policy %q", policy))
+	}
+
+	return func(next http.Handler) http.Handler {
+		if !m.cfg.RateLimit.Enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "middleware.RateLimit()"
+
+			key := rateLimitKey(r)
+
+			cost := 1.0
+			if policy == PolicyUpload {
+				cost = 0
+			}
+
+			wait, ok := limiter.Allow(key, cost)
+			if !ok {
+				m.log.Warn().Msgf("%s > %s limit of %s exceeded", op, policy, key)
+				m.tooManyRequests(w, r, wait)
+				return
+			}
+
+			if policy == PolicyUpload && r.Body != nil && r.Body != http.NoBody {
+				r.Body = &throttledReader{ReadCloser: r.Body, limiter: limiter, key: key, done: r.Context().Done()}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Return the key requests are counted by: the API token, the authenticated
+// user or the client's IP address
+func rateLimitKey(r *http.Request) string {
+	if token, ok := r.Context().Value(tokenContextKey).(*models.Token); ok {
+		return "token:" + strconv.Itoa(token.ID)
+	}
+
+	if user := template.AuthenticatedUser(r); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// Send 429 Too Many Requests telling the client when to try again, as JSON
+// for API routes
+func (m *Middleware) tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		m.er.APIError(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded, retry in %d seconds", seconds))
+		return
+	}
+
+	m.er.ClientError(w, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+}
+
+// Body charging the bytes read to the bucket of the key and waiting while
+// the bucket is in debt, until the request is canceled
+type throttledReader struct {
+	io.ReadCloser
+	limiter *ratelimit.Limiter
+	key     string
+	done    <-chan struct{}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	if wait := t.limiter.Charge(t.key, float64(n)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-t.done:
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alekslesik/file-cloud/internal/pkg/cserror"
+	"github.com/alekslesik/file-cloud/pkg/config"
+)
+
+// Return Middleware trusting the proxies
+func testMiddleware(t *testing.T, proxies ...string) *Middleware {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.RateLimit.TrustedProxies = proxies
+
+	m, err := New(nil, nil, cserror.New(), nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestTooManyRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		wait time.Duration
+		want string
+	}{
+		{"no wait", "/", 0, "1"},
+		{"under a second", "/", 10 * time.Millisecond, "1"},
+		{"one second", "/", time.Second, "1"},
+		{"rounded up", "/", 1001 * time.Millisecond, "2"},
+		{"minutes", "/", 2*time.Minute + 500*time.Millisecond, "121"},
+		{"API", "/api/files", 1500 * time.Millisecond, "2"},
+	}
+
+	m := testMiddleware(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			m.tooManyRequests(w, httptest.NewRequest(http.MethodGet, tt.path, nil), tt.wait)
+
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("got status %d; want %d", w.Code, http.StatusTooManyRequests)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("got Retry-After %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"no proxies", nil, "203.0.113.7:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7:1234"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.7:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-IP": {"198.51.100.2"}}, "203.0.113.7:1234"},
+		{"trusted proxy", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed hop", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"trusted chain", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.0.0.3, 10.0.0.2"}}, "198.51.100.1"},
+		{"headers of several proxies", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1", "10.0.0.2"}}, "198.51.100.1"},
+		{"invalid hop", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"only proxies", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"real IP", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded before real IP", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-IP": {"198.51.100.2"}}, "198.51.100.1"},
+		{"invalid real IP", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string][]string{"X-Real-IP": {"garbage"}}, "10.0.0.1:1234"},
+		{"IPv6", []string{"::1"}, "[::1]:1234",
+			map[string][]string{"X-Forwarded-For": {"2001:db8::1"}}, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMiddleware(t, tt.proxies...)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+
+			var got string
+			m.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("got remote address %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidProxies(t *testing.T) {
+	for _, proxies := range [][]string{{"10.0.0.256"}, {"10.0.0.0/33"}, {"10.0.0.1", "localhost"}} {
+		cfg := &config.Config{}
+		cfg.RateLimit.TrustedProxies = proxies
+
+		if _, err := New(nil, nil, cserror.New(), nil, cfg); err == nil {
+			t.Errorf("New with trusted proxies %q: got no error", proxies)
+		}
+	}
+}
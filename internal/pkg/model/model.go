@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/alekslesik/file-cloud/pkg/models"
 	"github.com/alekslesik/file-cloud/pkg/models/mysql"
@@ -9,20 +10,147 @@ import (
 
 func New(db *sql.DB) *Model {
 	return &Model{
-		Files: &mysql.FileModel{DB: db},
-		Users: &mysql.UserModel{DB: db},
+		Files:   &mysql.FileModel{DB: db},
+		Folders: &mysql.FolderModel{DB: db},
+		Users:   &mysql.UserModel{DB: db},
+		Uploads: &mysql.UploadModel{DB: db},
+		Shares:  &mysql.ShareModel{DB: db},
+		Grants:  &mysql.GrantModel{DB: db},
+		Orgs:    &mysql.OrgModel{DB: db},
+		Tokens:  &mysql.TokenModel{DB: db},
+		Quotas:  &mysql.QuotaModel{DB: db},
+		Logins:  &mysql.LoginModel{DB: db},
+
+		RecoveryCodes: &mysql.RecoveryCodeModel{DB: db},
+		Passkeys:      &mysql.PasskeyModel{DB: db},
 	}
 }
 
 type Model struct {
 	Files interface {
-		Insert(fileName string, fileType string, fileSize int64, fileUrl string, userID int) (int, error)
+		Insert(f *models.File, defaultQuota int64, place func(created bool) error) (int, error)
 		Get(id int) (*models.File, error)
-		All(userId int) ([]*models.File, error)
+		All(userId, orgId, folderId int) ([]*models.File, error)
+		Page(userId, orgId, folderId, limit, offset int) ([]*models.File, int, error)
+		Delete(id int, remove func(storageKey string) error) error
+		Rename(id int, name string) error
+		Move(id, folderID int) error
+		Trash(id int) error
+		Restore(id int) error
+		Trashed(userID int) ([]*models.File, error)
+		TrashedBefore(t time.Time) ([]*models.File, error)
+		DedupSavings(userID int) (int64, error)
+		Versions(fileID int) ([]*models.FileVersion, error)
+		Version(fileID, id int) (*models.FileVersion, error)
+		RestoreVersion(fileID, id int) error
+		PruneVersions(fileID, keep int, remove func(storageKey string) error) error
+	}
+	Folders interface {
+		Insert(userID, orgID, parentID int, name string) (int, error)
+		Get(id int) (*models.Folder, error)
+		Children(userID, orgID, parentID int) ([]*models.Folder, error)
+		All(userID, orgID int) ([]*models.Folder, error)
+		Path(id int) ([]*models.Folder, error)
+		Rename(id int, name string) error
+		Move(id, parentID int) error
 	}
 	Users interface {
-		Insert(name, email, password string) error
+		Insert(name, email, password string) (int, error)
 		Authenticate(email, password string) (int, string, error)
+		Activate(id int) error
+		SetPassword(id int, password string) error
+		SetTOTP(id int, secret string) error
+		UseTOTPStep(id int, step int64) error
+		SetMaxVersions(id, n int) error
 		Get(id int) (*models.User, error)
+		GetByEmail(email string) (*models.User, error)
+		GetByOIDC(issuer, subject string) (*models.User, error)
+		InsertOIDC(name, email, issuer, subject string) (int, error)
+		LinkOIDC(id int, issuer, subject string) error
+		ReplaceOIDC(id int, name, issuer, subject string) error
+		SyncLDAP(dn, name, email string, admin bool) (int, error)
+	}
+	Uploads interface {
+		Insert(u *models.Upload) error
+		Get(id string) (*models.Upload, error)
+		AddPart(id string, offset int64, size int64, storageKey string) error
+		Parts(id string) ([]*models.UploadPart, error)
+		Claim(id string) (bool, error)
+		Unclaim(id string) error
+		DeleteParts(id string) error
+		Delete(id string) error
+		Expired(t time.Time) ([]*models.Upload, error)
+	}
+	Shares interface {
+		Insert(s *models.Share, password string) (int, error)
+		Get(token string) (*models.Share, error)
+		All(userID int) ([]*models.Share, error)
+		Authenticate(id int, password string) error
+		Download(id int) error
+		Revoke(id, userID int) error
+	}
+	Grants interface {
+		Insert(g *models.Grant) error
+		Get(id int) (*models.Grant, error)
+		Delete(id int) error
+		File(fileID int) ([]*models.Grant, error)
+		Folder(folderID int) ([]*models.Grant, error)
+		Shared(userID int) ([]*models.Grant, error)
+		Permission(userID, fileID, folderID int) (models.Permission, error)
+	}
+	Orgs interface {
+		Insert(name string, userID int) (int, error)
+		Get(id int) (*models.Org, error)
+		ForUser(userID int) ([]*models.Org, error)
+		Members(orgID int) ([]*models.Member, error)
+		Member(orgID, userID int) (*models.Member, error)
+		SetRole(orgID, userID int, role models.Role) error
+		RemoveMember(orgID, userID int) error
+		Invite(inv *models.Invitation, invitedBy int, ttl time.Duration) (*models.Token, error)
+		Invitations(orgID int) ([]*models.Invitation, error)
+		Invitation(plaintext string) (*models.Invitation, error)
+		Accept(plaintext string, userID int) error
+		CancelInvitation(orgID, id int) error
+	}
+	Tokens interface {
+		New(userID int, ttl time.Duration, scope string) (*models.Token, error)
+		Get(scope, plaintext string) (*models.Token, error)
+		Consume(scope, plaintext string) (*models.Token, error)
+		Delete(plaintext string) error
+		DeleteAllForUser(scope string, userID int) error
+		DeleteUser(userID int) error
+		NewPersonal(userID int, name string, scopes []string, ttl time.Duration) (*models.Token, error)
+		Personal(userID int) ([]*models.Token, error)
+		DeletePersonal(userID, id int) error
+		Authenticate(plaintext string) (*models.Token, error)
+	}
+	Quotas interface {
+		Usage(userID, orgID int, defaultQuota int64) (*models.Usage, error)
+		Users(defaultQuota int64) ([]*models.Usage, error)
+		Orgs(defaultQuota int64) ([]*models.Usage, error)
+		Set(userID, orgID int, quota int64) error
+	}
+	RecoveryCodes interface {
+		Replace(userID int, codes []string) error
+		Use(userID int, code string) error
+		Remaining(userID int) (int, error)
+		DeleteAll(userID int) error
+	}
+	Passkeys interface {
+		Insert(p *models.Passkey) (int, error)
+		ForUser(userID int) ([]*models.Passkey, error)
+		GetByCredentialID(credentialID []byte) (*models.Passkey, error)
+		Used(id int, signCount uint32, backupState bool) error
+		Delete(userID, id int) error
+	}
+	Logins interface {
+		Attempt(email, ip string, since time.Time, check func(*models.LoginFailures) string) (int, string, error)
+		Release(id int) error
+		Failures(email, ip string, since time.Time) (*models.LoginFailures, error)
+		Lock(email string, until time.Time) (bool, error)
+		Succeed(email string) error
+		Lockouts() ([]*models.Lockout, error)
+		Unlock(id int) (string, error)
+		DeleteBefore(t time.Time) error
 	}
 }
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// How often buckets which refilled completely are forgotten
+const sweepInterval = time.Minute
+
+// Limiter keeps a token bucket for every key, e.g. a user or an IP address.
+// Buckets refill at rate tokens per second up to burst tokens. Limits are
+// kept in memory, so every instance of the app counts its own requests.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+
+	// Current time, replaced in tests
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Return Limiter refilling at rate tokens per second up to burst tokens
+func New(rate, burst float64) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Take n tokens from the bucket of the key if it has them. Otherwise report
+// how long to wait until it has. A bucket in debt after Charge admits
+// nothing, even with n of 0, until it refills.
+func (l *Limiter) Allow(key string, n float64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, l.now())
+
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64), false
+	}
+
+	return time.Duration((n - b.tokens) / l.rate * float64(time.Second)), false
+}
+
+// Take n tokens from the bucket of the key, which goes into debt if it
+// doesn't have them, and return how long until the debt is paid off. Used
+// for bytes already transferred.
+func (l *Limiter) Charge(key string, n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, l.now())
+	b.tokens -= n
+
+	if b.tokens >= 0 || l.rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// Return the bucket of the key refilled until now. Must be called with the
+// mutex held.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	return b
+}
+
+// Forget buckets which are full again, a new bucket is the same
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.swept = now
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Call of the limiter after the clock advanced. Charge is called if charge
+// is set, Allow otherwise.
+type step struct {
+	advance  time.Duration
+	key      string
+	n        float64
+	charge   bool
+	wantWait time.Duration
+	wantOK   bool
+}
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		steps []step
+	}{
+		{"burst", 1, 3, []step{
+			{0, "a", 1, false, 0, true},
+			{0, "a", 1, false, 0, true},
+			{0, "a", 1, false, 0, true},
+			{0, "a", 1, false, time.Second, false},
+		}},
+		{"refill", 2, 2, []step{
+			{0, "a", 2, false, 0, true},
+			{250 * time.Millisecond, "a", 1, false, 250 * time.Millisecond, false},
+			{250 * time.Millisecond, "a", 1, false, 0, true},
+			{0, "a", 1, false, 500 * time.Millisecond, false},
+		}},
+		{"refill up to burst", 1, 2, []step{
+			{0, "a", 2, false, 0, true},
+			{time.Hour, "a", 3, false, time.Second, false},
+			{0, "a", 2, false, 0, true},
+		}},
+		{"more than burst", 1, 2, []step{
+			{0, "a", 3, false, time.Second, false},
+			{0, "a", 2, false, 0, true},
+		}},
+		{"keys", 1, 1, []step{
+			{0, "a", 1, false, 0, true},
+			{0, "b", 1, false, 0, true},
+			{0, "a", 1, false, time.Second, false},
+		}},
+		{"debt", 10, 10, []step{
+			{0, "a", 30, true, 2 * time.Second, false},
+			{0, "a", 0, false, 2 * time.Second, false},
+			{time.Second, "a", 0, false, time.Second, false},
+			{time.Second, "a", 0, false, 0, true},
+			{0, "a", 1, false, 100 * time.Millisecond, false},
+		}},
+		{"charge within burst", 1, 5, []step{
+			{0, "a", 3, true, 0, false},
+			{0, "a", 2, false, 0, true},
+			{0, "a", 1, false, time.Second, false},
+		}},
+		{"burst at least one", 1, 0, []step{
+			{0, "a", 1, false, 0, true},
+			{0, "a", 1, false, time.Second, false},
+		}},
+		{"no refill", 0, 1, []step{
+			{0, "a", 1, false, 0, true},
+			{time.Hour, "a", 1, false, time.Duration(math.MaxInt64), false},
+			{0, "a", 5, true, 0, false},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := testLimiter(tt.rate, tt.burst)
+
+			for i, s := range tt.steps {
+				*clock = clock.Add(s.advance)
+
+				if s.charge {
+					if wait := l.Charge(s.key, s.n); wait != s.wantWait {
+						t.Errorf("step %d: Charge(%q, %v) = %v; want %v", i, s.key, s.n, wait, s.wantWait)
+					}
+					continue
+				}
+
+				wait, ok := l.Allow(s.key, s.n)
+				if wait != s.wantWait || ok != s.wantOK {
+					t.Errorf("step %d: Allow(%q, %v) = %v, %t; want %v, %t", i, s.key, s.n, wait, ok, s.wantWait, s.wantOK)
+				}
+			}
+		})
+	}
+}
+
+// Buckets which refilled completely are forgotten, others are kept with
+// their tokens
+func TestLimiterSweep(t *testing.T) {
+	l, clock := testLimiter(1, 100)
+
+	l.Allow("full", 1)
+	l.Allow("used", 100)
+
+	*clock = clock.Add(sweepInterval)
+	l.Allow("new", 0)
+
+	if _, ok := l.buckets["full"]; ok {
+		t.Error("full bucket kept after sweep")
+	}
+
+	if wait, ok := l.Allow("used", 100); ok || wait != 40*time.Second {
+		t.Errorf("Allow of used bucket after sweep = %v, %t; want 40s, false", wait, ok)
+	}
+}
+
+// Return a limiter and the time it sees, which only changes when set
+func testLimiter(rate, burst float64) (*Limiter, *time.Time) {
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(rate, burst)
+	l.now = func() time.Time { return clock }
+	l.swept = clock
+
+	return l, &clock
+}
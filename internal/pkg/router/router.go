@@ -6,6 +6,7 @@ import (
 	"github.com/alekslesik/file-cloud/internal/app/endpoint"
 	"github.com/alekslesik/file-cloud/internal/pkg/middleware"
 	"github.com/alekslesik/file-cloud/internal/pkg/session"
+	"github.com/alekslesik/file-cloud/pkg/models"
 	"github.com/bmizerany/pat"
 	"github.com/justinas/alice"
 )
@@ -27,11 +28,22 @@ func New(edp *endpoint.Endpoint, mdw *middleware.Middleware, ses *session.Sessio
 func (r *Router) Route() http.Handler {
 	// Create a middleware chain containing our 'standard' middleware
 	// which will be used for every request our application receives.
-	standardMiddleware := alice.New(r.mdw.RecoverPanic, r.mdw.LogRequest, r.mdw.SecureHeaders)
+	standardMiddleware := alice.New(r.mdw.RecoverPanic, r.mdw.RealIP, r.mdw.LogRequest, r.mdw.SecureHeaders)
 
 	// Create a new middleware chain containing the middleware specific to
 	// our dynamic application routes.
-	dynamicMiddleware := alice.New(r.ses.Enable, r.mdw.NoSurf, r.mdw.Authenticate)
+	dynamicMiddleware := alice.New(r.ses.Enable, r.mdw.NoSurf, r.mdw.Authenticate, r.mdw.RateLimit(middleware.PolicyRequests))
+
+	// Forms checking credentials or sending mails, with stricter limits.
+	authMiddleware := dynamicMiddleware.Append(r.mdw.RateLimit(middleware.PolicyAuth))
+
+	// Routes available to authenticated users only.
+	protectedMiddleware := dynamicMiddleware.Append(r.mdw.RequireAuthenticatedUser)
+
+	// Routes streaming file contents in the request body. They need longer
+	// deadlines and check the CSRF token by themselves.
+	uploadMiddleware := alice.New(r.mdw.ExtendDeadlines, r.ses.Enable, r.mdw.NoSurfStream, r.mdw.Authenticate, r.mdw.RequireAuthenticatedUser,
+		r.mdw.RateLimit(middleware.PolicyRequests), r.mdw.RateLimit(middleware.PolicyUpload))
 
 	// New pat router with REST
 	mux := pat.New()
@@ -39,13 +51,114 @@ func (r *Router) Route() http.Handler {
 	mux.Get("/healthcheck", dynamicMiddleware.ThenFunc(r.edp.HealthcheckHandler))
 	mux.Get("/", dynamicMiddleware.ThenFunc(r.edp.HomeGet))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(r.edp.UserLoginGet))
-	mux.Post("/user/login", dynamicMiddleware.ThenFunc(r.edp.UserLoginPost))
+	mux.Post("/user/login", authMiddleware.ThenFunc(r.edp.UserLoginPost))
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(r.edp.UserSignupGet))
-	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(r.edp.UserSignupPost))
+	mux.Post("/user/signup", authMiddleware.ThenFunc(r.edp.UserSignupPost))
 	mux.Get("/user/logout", dynamicMiddleware.ThenFunc(r.edp.UserLogoutGet))
+	mux.Get("/user/activate", dynamicMiddleware.ThenFunc(r.edp.UserActivateGet))
+	mux.Post("/user/activate", authMiddleware.ThenFunc(r.edp.UserActivatePost))
+	mux.Post("/user/activation", authMiddleware.ThenFunc(r.edp.UserActivationResendPost))
+	mux.Get("/user/password/forgot", dynamicMiddleware.ThenFunc(r.edp.PasswordForgotGet))
+	mux.Post("/user/password/forgot", authMiddleware.ThenFunc(r.edp.PasswordForgotPost))
+	mux.Get("/user/password/reset", dynamicMiddleware.ThenFunc(r.edp.PasswordResetGet))
+	mux.Post("/user/password/reset", authMiddleware.ThenFunc(r.edp.PasswordResetPost))
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(r.edp.LoginTwoFactorGet))
+	mux.Post("/user/login/2fa", authMiddleware.ThenFunc(r.edp.LoginTwoFactorPost))
+	mux.Post("/user/login/passkey/begin", authMiddleware.ThenFunc(r.edp.PasskeyLoginBeginPost))
+	mux.Post("/user/login/passkey/finish", authMiddleware.ThenFunc(r.edp.PasskeyLoginFinishPost))
+	mux.Get("/user/oidc/login", dynamicMiddleware.ThenFunc(r.edp.OIDCLoginGet))
+	mux.Get("/user/oidc/callback", dynamicMiddleware.ThenFunc(r.edp.OIDCCallbackGet))
+	mux.Get("/user/account", protectedMiddleware.ThenFunc(r.edp.AccountGet))
+	mux.Post("/user/tokens", protectedMiddleware.ThenFunc(r.edp.AccountTokenCreatePost))
+	mux.Post("/user/tokens/:id/revoke", protectedMiddleware.ThenFunc(r.edp.AccountTokenRevokePost))
+	mux.Post("/user/versions", protectedMiddleware.ThenFunc(r.edp.AccountVersionsPost))
+	mux.Get("/user/2fa/setup", protectedMiddleware.ThenFunc(r.edp.TwoFactorSetupGet))
+	mux.Post("/user/2fa/setup", protectedMiddleware.ThenFunc(r.edp.TwoFactorSetupPost))
+	mux.Get("/user/2fa/qr.png", protectedMiddleware.ThenFunc(r.edp.TwoFactorQRGet))
+	mux.Post("/user/2fa/confirm", protectedMiddleware.ThenFunc(r.edp.TwoFactorConfirmPost))
+	mux.Post("/user/2fa/disable", protectedMiddleware.ThenFunc(r.edp.TwoFactorDisablePost))
+	mux.Post("/user/2fa/recovery", protectedMiddleware.ThenFunc(r.edp.TwoFactorRecoveryPost))
+	mux.Post("/user/passkeys/register/begin", protectedMiddleware.ThenFunc(r.edp.PasskeyRegisterBeginPost))
+	mux.Post("/user/passkeys/register/finish", protectedMiddleware.ThenFunc(r.edp.PasskeyRegisterFinishPost))
+	mux.Post("/user/passkeys/:id/delete", protectedMiddleware.ThenFunc(r.edp.PasskeyDeletePost))
 	mux.Get("/files", dynamicMiddleware.ThenFunc(r.edp.FileUploadGet))
-	mux.Post("/files", dynamicMiddleware.ThenFunc(r.edp.FileUploadPost))
-	mux.Get("/download/:filename", dynamicMiddleware.ThenFunc(r.edp.FileDownloadGet))
+	mux.Post("/files", uploadMiddleware.ThenFunc(r.edp.FileUploadPost))
+	mux.Post("/files/:id/delete", protectedMiddleware.ThenFunc(r.edp.FileDeletePost))
+	mux.Post("/files/:id/rename", protectedMiddleware.ThenFunc(r.edp.FileRenamePost))
+	mux.Post("/files/:id/move", protectedMiddleware.ThenFunc(r.edp.FileMovePost))
+	mux.Get("/files/:id", protectedMiddleware.ThenFunc(r.edp.FileGet))
+	mux.Post("/files/:id/versions/:version/restore", protectedMiddleware.ThenFunc(r.edp.FileVersionRestorePost))
+	mux.Post("/files/:id/shares", protectedMiddleware.ThenFunc(r.edp.ShareCreatePost))
+	mux.Post("/files/:id/access", protectedMiddleware.ThenFunc(r.edp.FileAccessPost))
+	mux.Post("/folders/:id/access", protectedMiddleware.ThenFunc(r.edp.FolderAccessPost))
+	mux.Post("/access/:id/revoke", protectedMiddleware.ThenFunc(r.edp.AccessRevokePost))
+	mux.Get("/shared", protectedMiddleware.ThenFunc(r.edp.SharedGet))
+	mux.Get("/shares", protectedMiddleware.ThenFunc(r.edp.SharesGet))
+	mux.Post("/shares/:id/revoke", protectedMiddleware.ThenFunc(r.edp.ShareRevokePost))
+	mux.Get("/s/:token", dynamicMiddleware.ThenFunc(r.edp.SharePageGet))
+	mux.Post("/s/:token", authMiddleware.ThenFunc(r.edp.SharePagePost))
+	mux.Get("/trash", protectedMiddleware.ThenFunc(r.edp.TrashGet))
+	mux.Post("/trash/empty", protectedMiddleware.ThenFunc(r.edp.TrashEmptyPost))
+	mux.Post("/trash/:id/restore", protectedMiddleware.ThenFunc(r.edp.TrashRestorePost))
+	mux.Post("/trash/:id/delete", protectedMiddleware.ThenFunc(r.edp.TrashDeletePost))
+	mux.Get("/orgs", protectedMiddleware.ThenFunc(r.edp.OrgsGet))
+	mux.Post("/orgs", protectedMiddleware.ThenFunc(r.edp.OrgCreatePost))
+	mux.Get("/orgs/:id", protectedMiddleware.ThenFunc(r.edp.OrgGet))
+	mux.Post("/orgs/:id/invitations", protectedMiddleware.ThenFunc(r.edp.OrgInvitePost))
+	mux.Post("/orgs/:id/invitations/:invitation/cancel", protectedMiddleware.ThenFunc(r.edp.OrgInvitationCancelPost))
+	mux.Post("/orgs/:id/members/:user/role", protectedMiddleware.ThenFunc(r.edp.OrgMemberRolePost))
+	mux.Post("/orgs/:id/members/:user/remove", protectedMiddleware.ThenFunc(r.edp.OrgMemberRemovePost))
+	mux.Get("/invitations/:token", dynamicMiddleware.ThenFunc(r.edp.InvitationGet))
+	mux.Post("/invitations/:token", protectedMiddleware.ThenFunc(r.edp.InvitationAcceptPost))
+	mux.Post("/folders", protectedMiddleware.ThenFunc(r.edp.FolderCreatePost))
+	mux.Post("/folders/:id/rename", protectedMiddleware.ThenFunc(r.edp.FolderRenamePost))
+	mux.Post("/folders/:id/move", protectedMiddleware.ThenFunc(r.edp.FolderMovePost))
+
+	// Administration routes.
+	adminMiddleware := protectedMiddleware.Append(r.mdw.RequireAdmin)
+	mux.Get("/admin/quotas", adminMiddleware.ThenFunc(r.edp.AdminQuotasGet))
+	mux.Post("/admin/users/:id/quota", adminMiddleware.ThenFunc(r.edp.AdminUserQuotaPost))
+	mux.Post("/admin/users/:id/2fa/reset", adminMiddleware.ThenFunc(r.edp.AdminUserTwoFactorResetPost))
+	mux.Post("/admin/lockouts/:id/delete", adminMiddleware.ThenFunc(r.edp.AdminLockoutDeletePost))
+	mux.Post("/admin/orgs/:id/quota", adminMiddleware.ThenFunc(r.edp.AdminOrgQuotaPost))
+
+	// Routes streaming file contents in the response.
+	downloadMiddleware := alice.New(r.mdw.ExtendDeadlines, r.mdw.Unbuffered(alice.New(r.ses.Enable, r.mdw.Authenticate, r.mdw.RequireAuthenticatedUser, r.mdw.RateLimit(middleware.PolicyRequests))))
+	mux.Get("/files/:id/download", downloadMiddleware.ThenFunc(r.edp.FileDownloadGet))
+	mux.Get("/files/:id/versions/:version/download", downloadMiddleware.ThenFunc(r.edp.FileVersionDownloadGet))
+
+	// Public share downloads, the session only holds unlocked passwords.
+	shareDownloadMiddleware := alice.New(r.mdw.ExtendDeadlines, r.mdw.Unbuffered(alice.New(r.ses.Enable, r.mdw.RateLimit(middleware.PolicyRequests))))
+	mux.Get("/s/:token/download", shareDownloadMiddleware.ThenFunc(r.edp.ShareDownloadGet))
+
+	// Resumable uploads (tus protocol)
+	tusMiddleware := alice.New(r.mdw.ExtendDeadlines).Extend(protectedMiddleware)
+	mux.Options("/files/tus", http.HandlerFunc(r.edp.TusOptions))
+	mux.Options("/files/tus/:id", http.HandlerFunc(r.edp.TusOptions))
+	mux.Post("/files/tus", tusMiddleware.ThenFunc(r.edp.TusCreatePost))
+	mux.Head("/files/tus/:id", tusMiddleware.ThenFunc(r.edp.TusHead))
+	mux.Patch("/files/tus/:id", tusMiddleware.Append(r.mdw.RateLimit(middleware.PolicyUpload)).ThenFunc(r.edp.TusPatch))
+	mux.Del("/files/tus/:id", tusMiddleware.ThenFunc(r.edp.TusDelete))
+
+	// JSON API authenticated with bearer tokens. It doesn't use sessions, so
+	// CSRF protection is not needed.
+	// Personal access tokens need the scope of the route.
+	apiMiddleware := alice.New(r.mdw.AuthenticateToken, r.mdw.RateLimit(middleware.PolicyRequests))
+	apiProtectedMiddleware := apiMiddleware.Append(r.mdw.RequireToken)
+	apiReadMiddleware := apiProtectedMiddleware.Append(r.mdw.RequireScope(models.ScopeFilesRead))
+	apiWriteMiddleware := apiProtectedMiddleware.Append(r.mdw.RequireScope(models.ScopeFilesWrite))
+	mux.Post("/api/v1/tokens", apiMiddleware.Append(r.mdw.RateLimit(middleware.PolicyAuth)).ThenFunc(r.edp.APITokenCreatePost))
+	mux.Del("/api/v1/tokens", apiProtectedMiddleware.ThenFunc(r.edp.APITokenDelete))
+	mux.Get("/api/v1/user", apiProtectedMiddleware.ThenFunc(r.edp.APIUserGet))
+	mux.Get("/api/v1/files", apiReadMiddleware.ThenFunc(r.edp.APIFilesGet))
+	mux.Post("/api/v1/files", alice.New(r.mdw.ExtendDeadlines).Extend(apiWriteMiddleware).Append(r.mdw.RateLimit(middleware.PolicyUpload)).ThenFunc(r.edp.APIFileCreatePost))
+	mux.Get("/api/v1/files/:id", apiReadMiddleware.ThenFunc(r.edp.APIFileGet))
+	mux.Patch("/api/v1/files/:id", apiWriteMiddleware.ThenFunc(r.edp.APIFilePatch))
+	mux.Del("/api/v1/files/:id", apiWriteMiddleware.ThenFunc(r.edp.APIFileDelete))
+	mux.Get("/api/v1/files/:id/download", alice.New(r.mdw.ExtendDeadlines).Extend(apiReadMiddleware).ThenFunc(r.edp.APIFileDownloadGet))
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		mux.Add(method, "/api/", http.HandlerFunc(r.edp.APINotFound))
+	}
 
 	// file server for static files
 	fileServer := http.FileServer(http.Dir("./website/static/"))
@@ -20,5 +20,7 @@ func New(secret *string) *Session {
 func (s *Session) setupSession() {
 	s.Lifetime = 5 * time.Minute
 	s.Secure = true
-	s.SameSite = http.SameSiteStrictMode
+	// Lax sends the cookie on the redirect back from the single sign-on
+	// provider, cross-site POST requests still go without it
+	s.SameSite = http.SameSiteLaxMode
 }
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Bucket keeps objects in a bucket of any S3 compatible object store
+// (AWS S3, MinIO, Ceph RGW and so on).
+type S3Bucket struct {
+	client   *minio.Client
+	bucket   string
+	partSize uint64
+}
+
+// Return S3 storage connected to endpoint, creating bucket if it not exists.
+// Uploads are sent in parts of partSize bytes.
+func NewS3Bucket(endpoint, region, bucket, accessKey, secretKey string, useSSL bool, partSize uint64) (*S3Bucket, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Bucket{client: client, bucket: bucket, partSize: partSize}, nil
+}
+
+func (s *S3Bucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	// Without a part size, uploads of unknown size are buffered in parts
+	// large enough for the maximum object size, over 500 MiB each.
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{PartSize: s.partSize})
+	return err
+}
+
+func (s *S3Bucket) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	// GetObject doesn't send any request until the first read, so check the
+	// object exists beforehand.
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *S3Bucket) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.err(err)
+	}
+
+	return &ObjectInfo{Key: key, Size: info.Size, Modified: info.LastModified}, nil
+}
+
+func (s *S3Bucket) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Bucket) Move(ctx context.Context, src, dst string) error {
+	// S3 can't rename objects, so copy on the server side and remove the
+	// source. ComposeObject copies objects larger than 5 GiB by parts.
+	_, err := s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src},
+	)
+	if err != nil {
+		return s.err(err)
+	}
+
+	return s.Delete(ctx, src)
+}
+
+func (s *S3Bucket) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	var objects []*ObjectInfo
+
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, &ObjectInfo{Key: info.Key, Size: info.Size, Modified: info.LastModified})
+	}
+
+	return objects, nil
+}
+
+// Convert missing object error to ErrNotExist
+func (s *S3Bucket) err(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotExist
+	}
+
+	return err
+}
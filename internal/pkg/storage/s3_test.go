@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// Return the S3 storage of the MinIO server at STORAGE_S3_TEST_ENDPOINT, e.g.
+// started with docker compose -f deployments/docker-compose.yml up minio.
+// The test is skipped without it.
+func testS3Bucket(t *testing.T) *S3Bucket {
+	t.Helper()
+
+	endpoint := os.Getenv("STORAGE_S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_S3_TEST_ENDPOINT is not set")
+	}
+
+	// The smallest part size S3 allows, so multipart uploads are cheap
+	s, err := NewS3Bucket(endpoint, "us-east-1", "file-cloud-test",
+		os.Getenv("STORAGE_S3_TEST_ACCESS_KEY"), os.Getenv("STORAGE_S3_TEST_SECRET_KEY"), false, 5<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestS3Bucket(t *testing.T) {
+	s := testS3Bucket(t)
+	ctx := context.Background()
+
+	prefix, err := NewKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix += "/"
+
+	t.Cleanup(func() {
+		objects, _ := s.List(ctx, prefix)
+		for _, o := range objects {
+			s.Delete(ctx, o.Key)
+		}
+	})
+
+	// Larger than a part, so the upload of unknown size goes in two parts
+	data := make([]byte, 6<<20)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	src, dst := prefix+"src", prefix+"dst"
+
+	if err = s.Put(ctx, src, bytes.NewReader(data), -1); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := s.Stat(ctx, src)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != src || info.Size != int64(len(data)) {
+		t.Errorf("Stat: got %s of %d bytes; want %s of %d bytes", info.Key, info.Size, src, len(data))
+	}
+
+	rc, err := s.Get(ctx, src)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if _, err = rc.Seek(int64(len(data))-10, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	tail, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(tail, data[len(data)-10:]) {
+		t.Errorf("Get: got %x after seeking; want %x", tail, data[len(data)-10:])
+	}
+
+	if err = s.Put(ctx, dst, bytes.NewReader([]byte("old")), 3); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Move replaces the object under dst
+	if err = s.Move(ctx, src, dst); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+
+	if _, err = s.Stat(ctx, src); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of moved source: got %v; want ErrNotExist", err)
+	}
+
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != dst || objects[0].Size != int64(len(data)) {
+		t.Errorf("List: got %d objects; want %s of %d bytes only", len(objects), dst, len(data))
+	}
+
+	if err = s.Move(ctx, src, dst); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Move of missing source: got %v; want ErrNotExist", err)
+	}
+
+	if err = s.Delete(ctx, dst); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err = s.Get(ctx, dst); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Get of deleted object: got %v; want ErrNotExist", err)
+	}
+
+	// Deleting missing objects is not an error, like for local storage
+	if err = s.Delete(ctx, dst); err != nil {
+		t.Errorf("Delete of missing object: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/config"
+)
+
+const (
+	LOCAL = "local"
+	S3    = "s3"
+)
+
+var (
+	ErrNoDriver = errors.New("storage: driver not supported")
+	// If there is no object stored under the requested key.
+	ErrNotExist = errors.New("storage: object does not exist")
+)
+
+// Describe a stored object
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Backend is implemented by every storage driver. Objects are addressed by
+// slash separated keys and are always streamed, never loaded into memory.
+type Backend interface {
+	// Put stores everything read from r under key. Size is the number of
+	// bytes to be read or -1 if it is unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the object stored under key. The caller must close it.
+	// Seeking lets to read any part of the object, which drivers do without
+	// fetching the preceding data.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// Move renames the object, replacing any object stored under dst.
+	Move(ctx context.Context, src, dst string) error
+	// List returns all objects which keys start with prefix.
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+}
+
+// Open storage backend depends on driver
+func Open(cfg config.StorageConfig) (Backend, error) {
+	switch cfg.Driver {
+	case LOCAL:
+		return NewLocal(cfg.Local.Path)
+	case S3:
+		return NewS3Bucket(cfg.S3.Endpoint, cfg.S3.Region, cfg.S3.Bucket, cfg.S3.AccessKey, cfg.S3.SecretKey, cfg.S3.UseSSL, cfg.S3.PartSize)
+	default:
+		return nil, ErrNoDriver
+	}
+}
+
+// Return new unique key for an object owned by the user. The key is opaque:
+// it doesn't depend on the file name, so files with the same name never
+// overwrite each other.
+func NewKey(userID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("users/%d/%s", userID, hex.EncodeToString(b)), nil
+}
+
+// Return key of the content addressed object with the given SHA-256 hex
+// checksum. Keys are spread between subdirectories by the first two digits.
+func BlobKey(checksum string) string {
+	return fmt.Sprintf("blobs/%s/%s", checksum[:2], checksum)
+}
@@ -16,8 +16,9 @@ import (
 )
 
 const (
-	UserID   = "userID"
-	UserName = "userName"
+	UserID         = "userID"
+	UserName       = "userName"
+	SessionVersion = "sessionVersion"
 )
 
 type ClientServerError interface {
@@ -42,6 +43,40 @@ type TemplateData struct {
 	Form              *forms.Form
 	File              *models.File
 	Files             []*models.File
+	Folder            *models.Folder
+	Folders           []*models.Folder
+	AllFolders        []*models.Folder
+	Breadcrumbs       []*models.Folder
+	DedupSavings      int64
+	TrashRetention    int
+	Versions          []*models.FileVersion
+	Uploader          string
+	Share             *models.Share
+	Shares            []*models.Share
+	Locked            bool
+	BaseURL           string
+	Permission        models.Permission
+	Grants            []*models.Grant
+	Org               *models.Org
+	Orgs              []*models.Org
+	Members           []*models.Member
+	Member            *models.Member
+	Invitations       []*models.Invitation
+	Invitation        *models.Invitation
+	Usage             *models.Usage
+	Quotas            []*models.Usage
+	OrgQuotas         []*models.Usage
+	Quota             int64
+	MaxVersions       int
+	Tokens            []*models.Token
+	NewToken          string
+	TOTPSecret        string
+	TOTPURI           string
+	RecoveryCodes     []string
+	RecoveryLeft      int
+	Passkeys          []*models.Passkey
+	SSOName           string
+	Lockouts          []*models.Lockout
 }
 
 func New(logger *logging.Logger) *Template {
@@ -61,11 +96,28 @@ func HumanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// Return size in bytes as a short human readable string, e.g. 1.5 MB
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 // Initialize a template.FuncMap object and store it in a global variable. This
 // essentially a string-keyed map which acts as a lookup between the names of o
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
 	"humanDate": HumanDate,
+	"humanSize": HumanSize,
 }
 
 // Add template cache of files in dir
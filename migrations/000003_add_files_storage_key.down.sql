@@ -0,0 +1,2 @@
+DROP INDEX idx_files_user_id ON files;
+ALTER TABLE files DROP COLUMN storage_key;
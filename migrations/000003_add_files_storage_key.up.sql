@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN storage_key VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX idx_files_user_id ON files (user_id);
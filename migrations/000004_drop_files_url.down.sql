@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN url VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE files SET url = CONCAT('/files/', id, '/download');
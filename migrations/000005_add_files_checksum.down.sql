@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN checksum;
+ALTER TABLE files MODIFY COLUMN size VARCHAR(255) NOT NULL;
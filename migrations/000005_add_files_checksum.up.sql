@@ -0,0 +1,2 @@
+ALTER TABLE files MODIFY COLUMN size BIGINT NOT NULL;
+ALTER TABLE files ADD COLUMN checksum CHAR(64) NOT NULL DEFAULT '';
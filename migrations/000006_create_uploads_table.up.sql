@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id CHAR(32) NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    INDEX idx_uploads_expires (expires),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS upload_parts (
+    upload_id CHAR(32) NOT NULL,
+    part_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    PRIMARY KEY (upload_id, part_offset),
+    FOREIGN KEY (upload_id) REFERENCES uploads (id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE files DROP FOREIGN KEY fk_files_blob_hash;
+ALTER TABLE files DROP COLUMN blob_hash;
+DROP TABLE IF EXISTS blobs;
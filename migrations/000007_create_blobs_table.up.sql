@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    hash CHAR(64) NOT NULL PRIMARY KEY,
+    storage_key VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    refcount INT NOT NULL,
+    created DATETIME NOT NULL
+);
+
+ALTER TABLE files ADD COLUMN blob_hash CHAR(64) NULL;
+ALTER TABLE files ADD CONSTRAINT fk_files_blob_hash FOREIGN KEY (blob_hash) REFERENCES blobs (hash);
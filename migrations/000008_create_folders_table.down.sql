@@ -0,0 +1,4 @@
+ALTER TABLE uploads DROP COLUMN folder_id;
+ALTER TABLE files DROP FOREIGN KEY fk_files_folder_id;
+ALTER TABLE files DROP COLUMN folder_id;
+DROP TABLE IF EXISTS folders;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS folders (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    parent_id INT NULL,
+    name VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    INDEX idx_folders_user_parent (user_id, parent_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_id) REFERENCES folders (id)
+);
+
+ALTER TABLE files ADD COLUMN folder_id INT NULL;
+ALTER TABLE files ADD CONSTRAINT fk_files_folder_id FOREIGN KEY (folder_id) REFERENCES folders (id);
+
+ALTER TABLE uploads ADD COLUMN folder_id INT NULL;
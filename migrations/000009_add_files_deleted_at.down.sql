@@ -0,0 +1,2 @@
+DROP INDEX idx_files_deleted_at ON files;
+ALTER TABLE files DROP COLUMN deleted_at;
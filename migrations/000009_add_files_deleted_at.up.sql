@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN deleted_at DATETIME NULL;
+CREATE INDEX idx_files_deleted_at ON files (deleted_at);
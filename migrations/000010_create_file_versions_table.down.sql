@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS file_versions;
+ALTER TABLE files DROP COLUMN uploader_id;
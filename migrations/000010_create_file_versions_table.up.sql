@@ -0,0 +1,17 @@
+ALTER TABLE files ADD COLUMN uploader_id INT NULL;
+UPDATE files SET uploader_id = user_id;
+
+CREATE TABLE IF NOT EXISTS file_versions (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    file_id INT NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    checksum CHAR(64) NOT NULL DEFAULT '',
+    blob_hash CHAR(64) NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    user_id INT NOT NULL,
+    created DATETIME NOT NULL,
+    INDEX idx_file_versions_file (file_id, created),
+    FOREIGN KEY (file_id) REFERENCES files (id),
+    FOREIGN KEY (blob_hash) REFERENCES blobs (hash)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS shares (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token CHAR(32) NOT NULL,
+    file_id INT NOT NULL,
+    user_id INT NOT NULL,
+    hashed_password CHAR(60) NULL,
+    expires DATETIME NULL,
+    max_downloads INT NULL,
+    downloads INT NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL,
+    UNIQUE INDEX idx_shares_token (token),
+    INDEX idx_shares_user (user_id),
+    FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS grants (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    owner_id INT NOT NULL,
+    user_id INT NOT NULL,
+    file_id INT NULL,
+    folder_id INT NULL,
+    permission VARCHAR(16) NOT NULL,
+    created DATETIME NOT NULL,
+    UNIQUE INDEX idx_grants_user_file (user_id, file_id),
+    UNIQUE INDEX idx_grants_user_folder (user_id, folder_id),
+    FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE,
+    FOREIGN KEY (folder_id) REFERENCES folders (id) ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+ALTER TABLE uploads DROP COLUMN org_id;
+ALTER TABLE folders DROP FOREIGN KEY fk_folders_org_id;
+ALTER TABLE folders DROP COLUMN org_id;
+ALTER TABLE files DROP FOREIGN KEY fk_files_org_id;
+ALTER TABLE files DROP COLUMN org_id;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS orgs;
+DELETE FROM tokens;
+ALTER TABLE tokens MODIFY COLUMN hash BINARY(16) NOT NULL;
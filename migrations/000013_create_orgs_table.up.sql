@@ -0,0 +1,39 @@
+DELETE FROM tokens;
+ALTER TABLE tokens MODIFY COLUMN hash BINARY(32) NOT NULL;
+
+CREATE TABLE IF NOT EXISTS orgs (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (org_id, user_id),
+    INDEX idx_memberships_user (user_id),
+    FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    created DATETIME NOT NULL,
+    UNIQUE INDEX idx_invitations_token (token_hash),
+    FOREIGN KEY (org_id) REFERENCES orgs (id) ON DELETE CASCADE,
+    FOREIGN KEY (token_hash) REFERENCES tokens (hash) ON DELETE CASCADE
+);
+
+ALTER TABLE files ADD COLUMN org_id INT NULL;
+ALTER TABLE files ADD CONSTRAINT fk_files_org_id FOREIGN KEY (org_id) REFERENCES orgs (id);
+
+ALTER TABLE folders ADD COLUMN org_id INT NULL;
+ALTER TABLE folders ADD CONSTRAINT fk_folders_org_id FOREIGN KEY (org_id) REFERENCES orgs (id);
+
+ALTER TABLE uploads ADD COLUMN org_id INT NULL;
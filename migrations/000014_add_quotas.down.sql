@@ -0,0 +1,2 @@
+ALTER TABLE orgs DROP COLUMN used, DROP COLUMN quota;
+ALTER TABLE users DROP COLUMN used, DROP COLUMN quota;
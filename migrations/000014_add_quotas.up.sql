@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN quota BIGINT NULL, ADD COLUMN used BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orgs ADD COLUMN quota BIGINT NULL, ADD COLUMN used BIGINT NOT NULL DEFAULT 0;
+
+UPDATE users u SET used =
+    (SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.user_id = u.id AND f.org_id IS NULL) +
+    (SELECT COALESCE(SUM(v.size), 0) FROM file_versions v JOIN files f ON f.id = v.file_id
+    WHERE f.user_id = u.id AND f.org_id IS NULL);
+
+UPDATE orgs o SET used =
+    (SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.org_id = o.id) +
+    (SELECT COALESCE(SUM(v.size), 0) FROM file_versions v JOIN files f ON f.id = v.file_id
+    WHERE f.org_id = o.id);
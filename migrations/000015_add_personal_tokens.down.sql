@@ -0,0 +1,7 @@
+DELETE FROM tokens WHERE name IS NOT NULL;
+
+ALTER TABLE tokens
+    DROP COLUMN last_used,
+    DROP COLUMN created,
+    DROP COLUMN name,
+    DROP COLUMN id;
@@ -0,0 +1,5 @@
+ALTER TABLE tokens
+    ADD COLUMN id INT NOT NULL AUTO_INCREMENT UNIQUE FIRST,
+    ADD COLUMN name VARCHAR(255) NULL,
+    ADD COLUMN created DATETIME NULL,
+    ADD COLUMN last_used DATETIME NULL;
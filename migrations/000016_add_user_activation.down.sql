@@ -0,0 +1,3 @@
+DELETE FROM tokens WHERE scope = 'activation';
+
+ALTER TABLE users DROP COLUMN activated;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN activated BOOL NOT NULL DEFAULT FALSE;
+
+-- Users signed up before email verification keep access
+UPDATE users SET activated = TRUE;
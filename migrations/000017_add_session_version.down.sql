@@ -0,0 +1,3 @@
+DELETE FROM tokens WHERE scope = 'password-reset';
+
+ALTER TABLE users DROP COLUMN session_version;
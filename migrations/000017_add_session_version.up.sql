@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN session_version INT NOT NULL DEFAULT 1;
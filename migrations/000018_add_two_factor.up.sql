@@ -0,0 +1,12 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL,
+    ADD COLUMN totp_step BIGINT NULL;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    hash BINARY(32) NOT NULL,
+    used DATETIME NULL,
+    UNIQUE INDEX idx_recovery_codes_user_hash (user_id, hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
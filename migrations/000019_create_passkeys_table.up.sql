@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    credential_id VARBINARY(1023) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL,
+    transports VARCHAR(255) NOT NULL,
+    aaguid BINARY(16) NULL,
+    sign_count INT UNSIGNED NOT NULL,
+    backup_eligible BOOL NOT NULL,
+    backup_state BOOL NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    UNIQUE INDEX idx_passkeys_credential (credential_id),
+    INDEX idx_passkeys_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
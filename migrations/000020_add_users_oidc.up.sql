@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN oidc_issuer VARCHAR(255) NULL,
+    ADD COLUMN oidc_subject VARCHAR(255) NULL,
+    ADD UNIQUE INDEX idx_users_oidc (oidc_issuer, oidc_subject);
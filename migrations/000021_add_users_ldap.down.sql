@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP INDEX idx_users_ldap_dn,
+    DROP COLUMN admin,
+    DROP COLUMN ldap_dn;
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN ldap_dn VARCHAR(255) NULL,
+    ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD UNIQUE INDEX idx_users_ldap_dn (ldap_dn);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    created DATETIME NOT NULL,
+    INDEX idx_login_failures_email (email, created),
+    INDEX idx_login_failures_ip (ip, created)
+);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    until DATETIME NOT NULL,
+    created DATETIME NOT NULL,
+    UNIQUE INDEX idx_login_lockouts_email (email)
+);
@@ -0,0 +1 @@
+ALTER TABLE uploads ADD COLUMN finished BOOLEAN NOT NULL DEFAULT FALSE;
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"sync"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -15,6 +16,8 @@ type AppConfig struct {
 		Email    string `env:"ADMIN_EMAIL" env-default:"admin"`
 		Password string `env:"ADMIN_PWD" env-default:"admin"`
 	}
+	// Public URL of the site used in links of mails, e.g. https://cloud.example.com
+	BaseURL string `env:"WEB_BASE_URL" env-default:"https://localhost:8080"`
 }
 
 type LoggerConfig struct {
@@ -46,6 +49,122 @@ type SMTPConfig struct {
 	Sender   string
 }
 
+type StorageConfig struct {
+	Driver string `env:"STORAGE_DRIVER" env-default:"local"`
+	Local  struct {
+		Path string `env:"STORAGE_LOCAL_PATH" env-default:"./website/upload"`
+	}
+	S3 struct {
+		Endpoint  string `env:"STORAGE_S3_ENDPOINT" env-default:"localhost:9000"`
+		Region    string `env:"STORAGE_S3_REGION" env-default:"us-east-1"`
+		Bucket    string `env:"STORAGE_S3_BUCKET" env-default:"file-cloud"`
+		AccessKey string `env:"STORAGE_S3_ACCESS_KEY"`
+		SecretKey string `env:"STORAGE_S3_SECRET_KEY"`
+		UseSSL    bool   `env:"STORAGE_S3_USE_SSL" env-default:"false"`
+		// Size of the parts of uploads, each one is buffered in memory when
+		// the size of the upload is unknown. At least 5 MiB.
+		PartSize uint64 `env:"STORAGE_S3_PART_SIZE" env-default:"16777216"`
+	}
+}
+
+type WebAuthnConfig struct {
+	// Domain passkeys are bound to, must match the host of the origins
+	RPID string `env:"WEBAUTHN_RP_ID" env-default:"localhost"`
+	// Comma separated origins the site is served from
+	Origins []string `env:"WEBAUTHN_ORIGINS" env-default:"https://localhost:8080"`
+}
+
+type OIDCConfig struct {
+	// Issuer URL of the identity provider, empty turns single sign-on off
+	Issuer       string `env:"OIDC_ISSUER"`
+	ClientID     string `env:"OIDC_CLIENT_ID"`
+	ClientSecret string `env:"OIDC_CLIENT_SECRET"`
+	// Callback URL registered at the provider, ends with /user/oidc/callback
+	RedirectURL string `env:"OIDC_REDIRECT_URL" env-default:"https://localhost:8080/user/oidc/callback"`
+	// Name of the provider on the login page
+	Name string `env:"OIDC_NAME" env-default:"SSO"`
+	// Create accounts for unknown users with a verified email
+	AutoProvision bool `env:"OIDC_AUTO_PROVISION" env-default:"true"`
+}
+
+type AuthConfig struct {
+	// Comma separated authenticators checking passwords in order: local, ldap
+	Backends []string `env:"AUTH_BACKENDS" env-default:"local"`
+	LDAP     LDAPConfig
+}
+
+type LDAPConfig struct {
+	// ldap:// or ldaps:// URL of the directory server
+	URL      string `env:"LDAP_URL" env-default:"ldap://localhost:389"`
+	StartTLS bool   `env:"LDAP_START_TLS" env-default:"false"`
+	// PEM file with the CA certificates of the server, system roots if empty
+	CACert string `env:"LDAP_CA_CERT"`
+	// Account searching users, anonymous if empty
+	BindDN       string `env:"LDAP_BIND_DN"`
+	BindPassword string `env:"LDAP_BIND_PASSWORD"`
+	BaseDN       string `env:"LDAP_BASE_DN"`
+	// Filter of the user logging in, %s is replaced by the login
+	UserFilter     string `env:"LDAP_USER_FILTER" env-default:"(&(objectClass=person)(mail=%s))"`
+	EmailAttribute string `env:"LDAP_EMAIL_ATTRIBUTE" env-default:"mail"`
+	NameAttribute  string `env:"LDAP_NAME_ATTRIBUTE" env-default:"cn"`
+	// Groups are searched under the base DN with the filter, %s is
+	// replaced by the user's DN. The memberOf attribute is read if empty.
+	GroupBaseDN string `env:"LDAP_GROUP_BASE_DN"`
+	GroupFilter string `env:"LDAP_GROUP_FILTER" env-default:"(|(member=%s)(uniqueMember=%s))"`
+	// Semicolon separated DNs of groups allowed to log in, everybody if empty
+	UserGroups []string `env:"LDAP_USER_GROUPS" env-separator:";"`
+	// Semicolon separated DNs of groups whose members are administrators
+	AdminGroups []string      `env:"LDAP_ADMIN_GROUPS" env-separator:";"`
+	Timeout     time.Duration `env:"LDAP_TIMEOUT" env-default:"10s"`
+}
+
+type LoginConfig struct {
+	// Failed logins are counted within the window
+	Window time.Duration `env:"LOGIN_FAILURE_WINDOW" env-default:"15m"`
+	// Wait after a failed login of an account, doubled by every further one
+	Delay    time.Duration `env:"LOGIN_DELAY" env-default:"1s"`
+	MaxDelay time.Duration `env:"LOGIN_MAX_DELAY" env-default:"30s"`
+	// Failures of an account locking it for the lockout time
+	MaxAccountFailures int           `env:"LOGIN_MAX_ACCOUNT_FAILURES" env-default:"10"`
+	Lockout            time.Duration `env:"LOGIN_LOCKOUT" env-default:"30m"`
+	// Failures from an IP address refusing its logins until they leave the window
+	MaxIPFailures int `env:"LOGIN_MAX_IP_FAILURES" env-default:"50"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `env:"RATE_LIMIT_ENABLED" env-default:"true"`
+	// Comma separated IP addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers are trusted
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+	// Requests per minute and burst of login, signup and other forms checking
+	// credentials or sending mails
+	AuthPerMinute float64 `env:"RATE_LIMIT_AUTH_PER_MINUTE" env-default:"10"`
+	AuthBurst     float64 `env:"RATE_LIMIT_AUTH_BURST" env-default:"20"`
+	// Requests per minute and burst of all other pages and API calls
+	RequestsPerMinute float64 `env:"RATE_LIMIT_REQUESTS_PER_MINUTE" env-default:"600"`
+	RequestBurst      float64 `env:"RATE_LIMIT_REQUEST_BURST" env-default:"200"`
+	// Uploaded bytes per second and burst in bytes
+	UploadBytesPerSecond float64 `env:"RATE_LIMIT_UPLOAD_BYTES_PER_SECOND" env-default:"52428800"`
+	UploadBurst          float64 `env:"RATE_LIMIT_UPLOAD_BURST" env-default:"1073741824"`
+}
+
+type FilesConfig struct {
+	// Maximum size of one uploaded file in bytes
+	MaxUploadSize int64 `env:"FILES_MAX_UPLOAD_SIZE" env-default:"10737418240"`
+	// Read and write deadline of requests transferring file contents
+	TransferTimeout time.Duration `env:"FILES_TRANSFER_TIMEOUT" env-default:"2h"`
+	// Time to complete a resumable upload after it was created
+	UploadExpiration time.Duration `env:"FILES_UPLOAD_EXPIRATION" env-default:"24h"`
+	// How often expired data is cleaned up
+	CleanupInterval time.Duration `env:"FILES_CLEANUP_INTERVAL" env-default:"1h"`
+	// How long deleted files are kept in the trash
+	TrashRetention time.Duration `env:"FILES_TRASH_RETENTION" env-default:"720h"`
+	// How many prior versions of each file are kept
+	MaxVersions int `env:"FILES_MAX_VERSIONS" env-default:"10"`
+	// Default storage quota of users and organizations in bytes, 0 for unlimited
+	Quota int64 `env:"FILES_QUOTA" env-default:"10737418240"`
+}
+
 type Config struct {
 	App     AppConfig
 	Logger  LoggerConfig
@@ -53,6 +172,18 @@ type Config struct {
 	Session SessionConfig
 	TLS     TlsConfig
 	SMTP    SMTPConfig
+	Storage StorageConfig
+	Files   FilesConfig
+	// Passkey login
+	WebAuthn WebAuthnConfig
+	// Single sign-on with an OpenID Connect provider
+	OIDC OIDCConfig
+	// Password checks of the login
+	Auth AuthConfig
+	// Brute-force protection of password logins
+	Login LoginConfig
+	// Request limits per user, API token or client
+	RateLimit RateLimitConfig
 }
 
 // Singleton pattern
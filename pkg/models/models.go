@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"strings"
 	"time"
 )
 
@@ -11,15 +12,279 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	//If a user tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	//If a resumable upload is resumed from an outdated offset.
+	ErrOffsetMismatch = errors.New("models: upload offset mismatch")
+	//If a folder is moved into itself or one of its subfolders.
+	ErrFolderCycle = errors.New("models: folder can't be moved into itself")
+	//If a folder already contains an entry with the same name.
+	ErrDuplicateName = errors.New("models: duplicate name")
+	//If a share link is expired or its downloads are used up.
+	ErrShareExpired = errors.New("models: share link expired")
+	//If the only owner of an organization is removed or demoted.
+	ErrLastOwner = errors.New("models: organization must have an owner")
+	//If a file doesn't fit into the storage quota of its space.
+	ErrQuotaExceeded = errors.New("models: storage quota exceeded")
+	//If a one-time code or recovery code is wrong or already used.
+	ErrInvalidCode = errors.New("models: invalid code")
+	//If a user logs in before confirming the email address.
+	ErrNotActivated = errors.New("models: user not activated")
 )
 
 type File struct {
+	ID         int
+	Name       string
+	Type       string
+	Size       int64
+	Checksum   string
+	Created    time.Time
+	StorageKey string
+	UserID     int
+	// Zero for files in the root folder
+	FolderID int
+	// Time the file was moved to the trash, zero if it wasn't
+	Deleted time.Time
+	// User who uploaded the current version
+	UploaderID int
+	// Zero for files in the personal space of the user
+	OrgID int
+}
+
+// Prior version of a file
+type FileVersion struct {
+	ID           int
+	FileID       int
+	Type         string
+	Size         int64
+	Checksum     string
+	Created      time.Time
+	StorageKey   string
+	UploaderID   int
+	UploaderName string
+}
+
+// Public link to a file
+type Share struct {
+	ID     int
+	Token  string
+	FileID int
+	UserID int
+	// Name of the shared file, set by listings
+	FileName string
+	// Whether the link is protected with a password
+	Protected bool
+	// Zero if the link doesn't expire
+	Expires time.Time
+	// Zero if downloads are not limited
+	MaxDownloads int
+	Downloads    int
+	Created      time.Time
+}
+
+// Report whether the link can't be used anymore at t
+func (s *Share) Expired(t time.Time) bool {
+	if !s.Expires.IsZero() && !t.Before(s.Expires) {
+		return true
+	}
+
+	return s.MaxDownloads > 0 && s.Downloads >= s.MaxDownloads
+}
+
+// Access level to a file or folder
+type Permission string
+
+const (
+	Viewer Permission = "viewer"
+	Editor Permission = "editor"
+	Owner  Permission = "owner"
+)
+
+// Report whether p allows everything q does
+func (p Permission) Includes(q Permission) bool {
+	rank := map[Permission]int{Viewer: 1, Editor: 2, Owner: 3}
+
+	return rank[q] > 0 && rank[p] >= rank[q]
+}
+
+// Access to a file or a folder with all its contents granted to a user
+type Grant struct {
+	ID      int
+	OwnerID int
+	UserID  int
+	// Zero unless a file is shared
+	FileID int
+	// Zero unless a folder is shared
+	FolderID   int
+	Permission Permission
+	Created    time.Time
+	// Name and email of the user, set by listings of the item
+	UserName  string
+	UserEmail string
+	// Name of the file or the folder and its owner, set by listings of the user
+	Name      string
+	OwnerName string
+}
+
+type Folder struct {
+	ID     int
+	UserID int
+	// Zero for folders in the root folder
+	ParentID int
+	Name     string
+	Created  time.Time
+	// Slash separated names from the root, set by listings of all folders
+	Path string
+	// Zero for folders in the personal space of the user
+	OrgID int
+}
+
+// Organization owning a shared storage space
+type Org struct {
 	ID      int
 	Name    string
+	Created time.Time
+	// Role of the user, set by listings of the user's organizations
+	Role Role
+}
+
+// Role of a member of an organization
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// Return permission of the role on files and folders of the organization
+func (r Role) Permission() Permission {
+	switch r {
+	case RoleOwner, RoleAdmin:
+		return Owner
+	case RoleMember:
+		return Editor
+	}
+
+	return ""
+}
+
+// Report whether the role can manage members of the organization
+func (r Role) Manages() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+type Member struct {
+	OrgID   int
+	UserID  int
+	Name    string
+	Email   string
+	Role    Role
+	Created time.Time
+}
+
+// Invitation to join an organization sent by email
+type Invitation struct {
+	ID      int
+	OrgID   int
+	OrgName string
+	Email   string
+	Role    Role
+	Created time.Time
+	Expires time.Time
+}
+
+// Scopes of tokens
+const (
+	ScopeInvitation     = "invitation"
+	ScopeActivation     = "activation"
+	ScopePasswordReset  = "password-reset"
+	ScopeAuthentication = "authentication"
+	// API scopes of personal access tokens
+	ScopeFilesRead  = "files:read"
+	ScopeFilesWrite = "files:write"
+)
+
+// Scopes users can give to personal access tokens
+var PersonalScopes = []string{ScopeFilesRead, ScopeFilesWrite}
+
+// Secret token sent to a user. Only the hash of the plaintext is stored.
+//
+// Personal access tokens have a name and space separated API scopes.
+type Token struct {
+	ID        int
+	Plaintext string
+	Hash      []byte
+	UserID    int
+	Expiry    time.Time
+	Scope     string
+	Name      string
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+// Return API scopes of the token
+func (t *Token) Scopes() []string {
+	return strings.Fields(t.Scope)
+}
+
+// Report whether the token grants the API scope. Tokens created with the
+// user's password grant all scopes.
+func (t *Token) Allows(scope string) bool {
+	if t.Scope == ScopeAuthentication {
+		return true
+	}
+
+	for _, s := range t.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report whether the token can't be used anymore
+func (t *Token) Expired() bool {
+	return !t.Expiry.After(time.Now())
+}
+
+// WebAuthn credential of a user, e.g. a passkey or a hardware key
+type Passkey struct {
+	ID              int
+	UserID          int
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	Created         time.Time
+	LastUsed        time.Time
+}
+
+// Partially uploaded file of a resumable upload
+type Upload struct {
+	ID      string
+	UserID  int
+	Name    string
 	Type    string
-	Size    string
+	Length  int64
+	Offset  int64
 	Created time.Time
-	URL     string
+	Expires time.Time
+	// Folder to put the file into when the upload is complete
+	FolderID int
+	// Organization to put the file into, zero for the personal space
+	OrgID int
+}
+
+// Stored chunk of a resumable upload
+type UploadPart struct {
+	Offset     int64
+	Size       int64
+	StorageKey string
 }
 
 type User struct {
@@ -28,4 +293,86 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Activated      bool
+	// Changed with the password to log out existing sessions
+	SessionVersion int
+	// Base32 TOTP secret, empty without two-factor authentication
+	TOTPSecret string
+	// Set for the administrator configured by ADMIN_EMAIL and members of
+	// LDAP administrator groups
+	Admin bool
+	// Signs in with the password of the LDAP directory
+	LDAP bool
+	// Prior versions kept of each file in the personal space,
+	// DefaultMaxVersions for the configured number
+	MaxVersions int
+}
+
+// Report whether the user logs in with a one-time code as second factor
+func (u *User) TwoFactor() bool {
+	return u.TOTPSecret != ""
+}
+
+// Quota value resetting the quota of a space to the configured default
+const DefaultQuota int64 = -1
+
+// Version limit of users keeping the configured number of versions
+const DefaultMaxVersions = -1
+
+// Failed logins of an account and an IP address within the counting window
+type LoginFailures struct {
+	Account int
+	IP      int
+	// Time of the latest failure of the account
+	Last time.Time
+	// End of the account's lockout, zero if it isn't locked
+	LockedUntil time.Time
+}
+
+// Account locked after too many failed logins
+type Lockout struct {
+	ID      int
+	Email   string
+	Until   time.Time
+	Created time.Time
+}
+
+// Storage usage of the personal space of a user or of an organization.
+// Versions and files in the trash count too.
+type Usage struct {
+	UserID int
+	OrgID  int
+	Name   string
+	Email  string
+	Used   int64
+	// Zero quota means unlimited storage
+	Quota int64
+	// Whether the quota was set for the space instead of the default
+	Custom bool
+}
+
+// Report whether the space has no quota
+func (u *Usage) Unlimited() bool {
+	return u.Quota == 0
+}
+
+// Return number of bytes which can still be stored in a space with a quota
+func (u *Usage) Left() int64 {
+	if u.Used >= u.Quota {
+		return 0
+	}
+
+	return u.Quota - u.Used
+}
+
+// Return used share of the quota in percent up to 100
+func (u *Usage) Percent() int {
+	if u.Unlimited() {
+		return 0
+	}
+	if u.Used >= u.Quota {
+		return 100
+	}
+
+	return int(u.Used * 100 / u.Quota)
 }
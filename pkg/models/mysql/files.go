@@ -3,6 +3,7 @@ package mysql
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/alekslesik/file-cloud/pkg/models"
 )
@@ -11,39 +12,380 @@ type FileModel struct {
 	DB *sql.DB
 }
 
-// Add a new record to the users table.
-func (m *FileModel) Insert(fileName string, fileType string, fileSize int64, fileUrl string, userID int) (int, error) {
+// Add a new record to the files table. Contents are kept in the blob with the
+// file checksum stored under f.StorageKey, the blob is created or its reference count is increased in
+// the same transaction. The place func is called while the blob row is locked
+// with created reporting whether the blob is new, so the caller can put new
+// contents under the key. The key of the existing blob is used for the file
+// otherwise, and the caller drops the duplicated contents once Insert
+// succeeded.
+//
+// If the folder already has a file with the same name, the contents become its
+// new version and the ID of that file is returned. The space is locked
+// meanwhile, so concurrent uploads of a name never add two files.
+//
+// The size is added to the used storage of the space. ErrQuotaExceeded is
+// returned if it doesn't fit into the space quota, which is defaultQuota
+// unless set for the space.
+func (m *FileModel) Insert(f *models.File, defaultQuota int64, place func(created bool) error) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = lockSpace(tx, f.UserID, f.OrgID); err != nil {
+		return 0, err
+	}
+
+	// MySQL reports 1 affected row for the inserted blob and 2 for the updated one.
+	stmt := `INSERT INTO blobs (hash, storage_key, size, refcount, created) VALUES(?, ?, ?, 1, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE refcount = refcount + 1`
+	result, err := tx.Exec(stmt, f.Checksum, f.StorageKey, f.Size)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	var storageKey string
+	err = tx.QueryRow(`SELECT storage_key FROM blobs WHERE hash = ?`, f.Checksum).Scan(&storageKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// Check the quota before the contents are placed
+	if err = reserveSpace(tx, f.UserID, f.OrgID, f.Size, defaultQuota); err != nil {
+		return 0, err
+	}
+
+	if err = place(n == 1); err != nil {
+		return 0, err
+	}
+
+	uploaderID := f.UploaderID
+	if uploaderID == 0 {
+		uploaderID = f.UserID
+	}
+
+	var existing int
+	cond, args := spaceCond(f.UserID, f.OrgID)
+	stmt = `SELECT id FROM files WHERE ` + cond + ` AND COALESCE(folder_id, 0) = ? AND name = ? AND deleted_at IS NULL
+	ORDER BY id LIMIT 1 FOR UPDATE`
+	err = tx.QueryRow(stmt, append(args, f.FolderID, f.Name)...).Scan(&existing)
+	if err == nil {
+		// Keep the current contents as a prior version
+		if err = saveVersion(tx, existing); err != nil {
+			return 0, err
+		}
+
+		stmt = `UPDATE files SET type = ?, size = ?, checksum = ?, blob_hash = ?, storage_key = ?, uploader_id = ?,
+		created = UTC_TIMESTAMP() WHERE id = ?`
+		_, err = tx.Exec(stmt, f.Type, f.Size, f.Checksum, f.Checksum, storageKey, uploaderID, existing)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return 0, err
+		}
+
+		return existing, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
 	// SQL request we wanted to execute
-	stmt := `INSERT INTO files (name, type, size, created, url, user_id) VALUES(?, ?, ?, UTC_TIMESTAMP(), ?, ?)`
+	stmt = `INSERT INTO files (name, type, size, checksum, blob_hash, created, storage_key, user_id, folder_id, uploader_id, org_id)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?, ?, ?, ?, ?)`
 
 	// Use Exec() for execute SQL request
-	result, err := m.DB.Exec(stmt, fileName, fileType, fileSize, fileUrl, userID)
+	result, err = tx.Exec(stmt, f.Name, f.Type, f.Size, f.Checksum, f.Checksum, storageKey, f.UserID, nullID(f.FolderID), uploaderID, nullID(f.OrgID))
 	if err != nil {
 		return 0, err
 	}
 
-	// Get the last created snippet ID from snippets table
+	// Get the last created file ID from files table
 	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return int(id), nil
 }
 
-// Return snippet data by ID
+// Delete the file record with all its versions and free their storage in the
+// space. The blob of the file is deleted as well when no other file refers to
+// it, and remove is called with its storage key after the commit, so contents
+// are never removed while a record still refers to them. Files stored before
+// blobs were introduced own their contents.
+func (m *FileModel) Delete(id int, remove func(storageKey string) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var blobHash sql.NullString
+	var storageKey string
+	var size int64
+	var userID, orgID int
+
+	stmt := `SELECT blob_hash, storage_key, size, user_id, COALESCE(org_id, 0) FROM files WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&blobHash, &storageKey, &size, &userID, &orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	versions, err := queryVersions(tx, `SELECT `+versionColumns+` FROM file_versions v
+	LEFT JOIN users u ON u.id = v.user_id WHERE v.file_id = ? FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+
+	var unused []contents
+	for _, v := range versions {
+		c, err := deleteVersion(tx, v.ID)
+		if err != nil {
+			return err
+		}
+		unused = append(unused, c...)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM files WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	if err = releaseSpace(tx, userID, orgID, size); err != nil {
+		return err
+	}
+
+	c, err := releaseContents(tx, blobHash, storageKey)
+	if err != nil {
+		return err
+	}
+	unused = append(unused, c...)
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return m.removeContents(unused, remove)
+}
+
+// Stored contents no record refers to anymore
+type contents struct {
+	blobHash   sql.NullString
+	storageKey string
+}
+
+// Release the blob or contents stored before blobs were introduced. Contents
+// which aren't used anymore are returned.
+func releaseContents(tx *sql.Tx, blobHash sql.NullString, storageKey string) ([]contents, error) {
+	if blobHash.Valid {
+		return releaseBlob(tx, blobHash.String)
+	}
+
+	return []contents{{storageKey: storageKey}}, nil
+}
+
+// Remove the contents released by a committed transaction from storage. A
+// blob uploaded again since then is kept: its row, or the gap where it
+// would be inserted, stays locked while its contents are removed. Contents
+// which fail to be removed are left orphaned, the other ones are removed
+// anyway.
+func (m *FileModel) removeContents(unused []contents, remove func(storageKey string) error) error {
+	var errs []error
+
+	for _, c := range unused {
+		if !c.blobHash.Valid {
+			if err := remove(c.storageKey); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := m.removeBlob(c.blobHash.String, c.storageKey, remove); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Remove the contents of the deleted blob unless it was uploaded again
+func (m *FileModel) removeBlob(hash, storageKey string, remove func(storageKey string) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM blobs WHERE hash = ? FOR UPDATE`, hash).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	if err = remove(storageKey); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rename the file. Contents are stored under a key which doesn't depend on
+// the name, so only the record changes. ErrDuplicateName is returned if
+// another file in the folder has the name.
+func (m *FileModel) Rename(id int, name string) error {
+	f, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return m.updateNamed(f, f.FolderID, name, `UPDATE files SET name = ? WHERE id = ?`, name)
+}
+
+// Move the file into the folder, zero is the root folder. Contents are stored
+// under a key which doesn't depend on the folder, so only the record changes.
+// ErrDuplicateName is returned if the folder has another file with the name.
+func (m *FileModel) Move(id, folderID int) error {
+	f, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return m.updateNamed(f, folderID, f.Name, `UPDATE files SET folder_id = ? WHERE id = ?`, nullID(folderID))
+}
+
+// Execute update stmt of the file with args followed by the file ID once the
+// folder of its space has no other file with the name. The space is locked
+// meanwhile, so concurrent changes can't give two files the same name.
+func (m *FileModel) updateNamed(f *models.File, folderID int, name string, stmt string, args ...any) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockSpace(tx, f.UserID, f.OrgID); err != nil {
+		return err
+	}
+
+	if err = m.checkName(tx, f.UserID, f.OrgID, folderID, name, f.ID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(stmt, append(args, f.ID)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Return ErrDuplicateName if the folder of the space has another file with
+// the name outside the trash. File except is not taken into account.
+func (m *FileModel) checkName(q querier, userID, orgID, folderID int, name string, except int) error {
+	cond, args := spaceCond(userID, orgID)
+	stmt := `SELECT COUNT(*) FROM files WHERE ` + cond + ` AND COALESCE(folder_id, 0) = ? AND name = ?
+	AND deleted_at IS NULL AND id <> ?`
+
+	var n int
+	if err := q.QueryRow(stmt, append(args, folderID, name, except)...).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return models.ErrDuplicateName
+	}
+
+	return nil
+}
+
+// Execute update stmt of the file with args followed by the file ID. Return
+// ErrNoRecord if there is no such file.
+func (m *FileModel) update(id int, stmt string, args ...any) error {
+	result, err := m.DB.Exec(stmt, append(args, id)...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL doesn't count rows which were not changed, so check the file exists
+	if n == 0 {
+		_, err = m.Get(id)
+		return err
+	}
+
+	return nil
+}
+
+// Decrease reference count of the blob and delete it when it's not
+// referenced anymore. The contents of the deleted blob are returned.
+func releaseBlob(tx *sql.Tx, hash string) ([]contents, error) {
+	var refcount int
+	var storageKey string
+
+	stmt := `SELECT refcount, storage_key FROM blobs WHERE hash = ? FOR UPDATE`
+	if err := tx.QueryRow(stmt, hash).Scan(&refcount, &storageKey); err != nil {
+		return nil, err
+	}
+
+	if refcount > 1 {
+		_, err := tx.Exec(`UPDATE blobs SET refcount = refcount - 1 WHERE hash = ?`, hash)
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM blobs WHERE hash = ?`, hash); err != nil {
+		return nil, err
+	}
+
+	return []contents{{blobHash: sql.NullString{String: hash, Valid: true}, storageKey: storageKey}}, nil
+}
+
+// Return number of bytes of files in the user's personal space which weren't
+// stored again because the space had the same contents before. Files of
+// other spaces don't count, so the savings don't reveal what others stored.
+func (m *FileModel) DedupSavings(userID int) (int64, error) {
+	stmt := `SELECT COALESCE(SUM(f.size), 0) FROM files f
+	WHERE f.user_id = ? AND f.org_id IS NULL AND f.blob_hash IS NOT NULL
+	AND f.deleted_at IS NULL
+	AND EXISTS (SELECT 1 FROM files g WHERE g.blob_hash = f.blob_hash AND g.id < f.id
+		AND g.user_id = f.user_id AND g.org_id IS NULL)`
+
+	var saved int64
+	err := m.DB.QueryRow(stmt, userID).Scan(&saved)
+
+	return saved, err
+}
+
+// Columns of files selected by queries, in order of scanFile()
+const fileColumns = `id, name, type, size, checksum, created, storage_key, user_id, COALESCE(folder_id, 0), deleted_at,
+	COALESCE(uploader_id, user_id), COALESCE(org_id, 0)`
+
+// Return file data by ID
 func (m *FileModel) Get(id int) (*models.File, error) {
 	// SQL request for getting data of one record
-	stmt := `SELECT id, name, type, size, created, url FROM files WHERE id = ?`
+	stmt := `SELECT ` + fileColumns + ` FROM files WHERE id = ?`
 
 	// Use QueryRow() for executing SQL request passing unreliable variable ID like a placeholder
 	row := m.DB.QueryRow(stmt, id)
 
-	// Initialise the pointer to new struct Snippet
-	s := &models.File{}
-
-	// Use row.Scan() to copy the value from every sql.Row field to Snippet Struct
-	err := row.Scan(&s.ID, &s.Name, &s.Type, &s.Size, &s.Created)
+	// Copy the value from every sql.Row field to File Struct
+	s, err := scanFile(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -52,17 +394,95 @@ func (m *FileModel) Get(id int) (*models.File, error) {
 		}
 	}
 
-	// If all ok return Snippet object
+	// If all ok return File object
 	return s, nil
 }
 
-// Return all files
-func (m *FileModel) All(userId int) ([]*models.File, error) {
+// Return all files of the folder in the user's space or, if orgId is not
+// zero, in the organization's one
+func (m *FileModel) All(userId, orgId, folderId int) ([]*models.File, error) {
+	cond, args := spaceCond(userId, orgId)
+
 	// SQL request we wanted to execute
-	stmt := `SELECT id, name, type, size, created, url FROM files WHERE user_id=? ORDER BY created`
+	stmt := `SELECT ` + fileColumns + ` FROM files
+	WHERE ` + cond + ` AND COALESCE(folder_id, 0) = ? AND deleted_at IS NULL ORDER BY created`
+
+	return m.query(stmt, append(args, folderId)...)
+}
+
+// Return limit files of the folder in the user's or organization's space
+// starting at offset, oldest first, and the number of all files in the folder
+func (m *FileModel) Page(userId, orgId, folderId, limit, offset int) ([]*models.File, int, error) {
+	cond, args := spaceCond(userId, orgId)
+	cond += ` AND COALESCE(folder_id, 0) = ? AND deleted_at IS NULL`
+	args = append(args, folderId)
+
+	var total int
+	if err := m.DB.QueryRow(`SELECT COUNT(*) FROM files WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	stmt := `SELECT ` + fileColumns + ` FROM files WHERE ` + cond + ` ORDER BY created, id LIMIT ? OFFSET ?`
+
+	files, err := m.query(stmt, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
+}
+
+// Move the file to the trash
+func (m *FileModel) Trash(id int) error {
+	return m.update(id, `UPDATE files SET deleted_at = UTC_TIMESTAMP() WHERE id = ?`)
+}
+
+// Restore the file from the trash. ErrDuplicateName is returned if its
+// folder has another file with the name by now.
+func (m *FileModel) Restore(id int) error {
+	f, err := m.Get(id)
+	if err != nil {
+		return err
+	}
 
+	return m.updateNamed(f, f.FolderID, f.Name, `UPDATE files SET deleted_at = NULL WHERE id = ?`)
+}
+
+// Return files in the trash of the user's space and of organizations the
+// user manages, recently deleted first
+func (m *FileModel) Trashed(userID int) ([]*models.File, error) {
+	stmt := `SELECT ` + fileColumns + ` FROM files WHERE deleted_at IS NOT NULL
+	AND ((user_id = ? AND org_id IS NULL)
+	OR org_id IN (SELECT org_id FROM memberships WHERE user_id = ? AND role IN ('owner', 'admin')))
+	ORDER BY deleted_at DESC`
+
+	return m.query(stmt, userID, userID)
+}
+
+// Return files of all users moved to the trash before t
+func (m *FileModel) TrashedBefore(t time.Time) ([]*models.File, error) {
+	stmt := `SELECT ` + fileColumns + ` FROM files WHERE deleted_at < ?`
+
+	return m.query(stmt, t.UTC())
+}
+
+// Return files stored before per-user storage keys were introduced
+func (m *FileModel) WithoutStorageKey() ([]*models.File, error) {
+	stmt := `SELECT ` + fileColumns + ` FROM files WHERE storage_key = '' ORDER BY id`
+
+	return m.query(stmt)
+}
+
+// Set storage key of the file
+func (m *FileModel) SetStorageKey(id int, storageKey string) error {
+	_, err := m.DB.Exec(`UPDATE files SET storage_key = ? WHERE id = ?`, storageKey, id)
+	return err
+}
+
+// Return files selected by stmt
+func (m *FileModel) query(stmt string, args ...any) ([]*models.File, error) {
 	// Use Query() for execute SQL request
-	rows, err := m.DB.Query(stmt, userId)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,9 +493,7 @@ func (m *FileModel) All(userId int) ([]*models.File, error) {
 
 	// Use rows.Next() to run over the result
 	for rows.Next() {
-		s := &models.File{}
-		// Use row.Scan() to copy the value from every sql.Row field to File Struct
-		err = rows.Scan(&s.ID, &s.Name, &s.Type, &s.Size, &s.Created, &s.URL)
+		s, err := scanFile(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -91,3 +509,18 @@ func (m *FileModel) All(userId int) ([]*models.File, error) {
 	// If all ok return slice
 	return files, nil
 }
+
+// Scan file selected with fileColumns
+func scanFile(row scanner) (*models.File, error) {
+	s := &models.File{}
+	var deleted sql.NullTime
+
+	err := row.Scan(&s.ID, &s.Name, &s.Type, &s.Size, &s.Checksum, &s.Created, &s.StorageKey, &s.UserID, &s.FolderID, &deleted, &s.UploaderID, &s.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Deleted = deleted.Time
+
+	return s, nil
+}
@@ -0,0 +1,260 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type FolderModel struct {
+	DB *sql.DB
+}
+
+// Add a new folder created by the user into the parent folder of the user's
+// space or, if orgID is not zero, of the organization. The space is locked
+// while checking the name, so concurrent requests never add two folders with
+// the same name.
+func (m *FolderModel) Insert(userID, orgID, parentID int, name string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = lockSpace(tx, userID, orgID); err != nil {
+		return 0, err
+	}
+
+	if err = m.checkName(tx, userID, orgID, parentID, name, 0); err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO folders (user_id, org_id, parent_id, name, created) VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := tx.Exec(stmt, userID, nullID(orgID), nullID(parentID), name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Return folder data by ID
+func (m *FolderModel) Get(id int) (*models.Folder, error) {
+	stmt := `SELECT ` + folderColumns + ` FROM folders WHERE id = ?`
+
+	f := &models.Folder{}
+	err := m.DB.QueryRow(stmt, id).Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.Created, &f.OrgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Columns of folders selected by queries
+const folderColumns = `id, user_id, COALESCE(parent_id, 0), name, created, COALESCE(org_id, 0)`
+
+// Return subfolders of the folder in the user's space or, if orgID is not
+// zero, in the organization's one, ordered by name
+func (m *FolderModel) Children(userID, orgID, parentID int) ([]*models.Folder, error) {
+	cond, args := spaceCond(userID, orgID)
+	stmt := `SELECT ` + folderColumns + ` FROM folders
+	WHERE ` + cond + ` AND COALESCE(parent_id, 0) = ? ORDER BY name`
+
+	return m.query(stmt, append(args, parentID)...)
+}
+
+// Return all folders of the user's space or, if orgID is not zero, of the
+// organization with their paths, ordered by path
+func (m *FolderModel) All(userID, orgID int) ([]*models.Folder, error) {
+	cond, args := spaceCond(userID, orgID)
+	stmt := `SELECT ` + folderColumns + ` FROM folders WHERE ` + cond
+
+	folders, err := m.query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.Folder, len(folders))
+	for _, f := range folders {
+		byID[f.ID] = f
+	}
+
+	for _, f := range folders {
+		f.Path = f.Name
+		for p := byID[f.ParentID]; p != nil; p = byID[p.ParentID] {
+			f.Path = p.Name + "/" + f.Path
+		}
+	}
+
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Path < folders[j].Path
+	})
+
+	return folders, nil
+}
+
+// Return folders from the root down to the folder, for breadcrumbs
+func (m *FolderModel) Path(id int) ([]*models.Folder, error) {
+	var path []*models.Folder
+
+	for id != 0 {
+		f, err := m.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		path = append([]*models.Folder{f}, path...)
+		id = f.ParentID
+	}
+
+	return path, nil
+}
+
+// Rename the folder. The space is locked while checking the name.
+func (m *FolderModel) Rename(id int, name string) error {
+	f, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockSpace(tx, f.UserID, f.OrgID); err != nil {
+		return err
+	}
+
+	if err = m.checkName(tx, f.UserID, f.OrgID, f.ParentID, name, id); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE folders SET name = ? WHERE id = ?`, name, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Move the folder with all its contents into the parent folder. Subfolders
+// refer to their parents only, so the whole subtree moves with one update.
+// The space, the folder and all ancestors of the new parent are locked while
+// checking the folder is not moved into its own subtree and the name.
+func (m *FolderModel) Move(id, parentID int) error {
+	f, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The space comes first, like for all changes checking names
+	if err = lockSpace(tx, f.UserID, f.OrgID); err != nil {
+		return err
+	}
+
+	var userID, orgID int
+	var name string
+
+	stmt := `SELECT user_id, COALESCE(org_id, 0), name FROM folders WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&userID, &orgID, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	for p := parentID; p != 0; {
+		if p == id {
+			return models.ErrFolderCycle
+		}
+
+		// Folders can't be moved to another space
+		var owner, org int
+		stmt = `SELECT user_id, COALESCE(org_id, 0), COALESCE(parent_id, 0) FROM folders WHERE id = ? FOR UPDATE`
+		err = tx.QueryRow(stmt, p).Scan(&owner, &org, &p)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNoRecord
+		} else if err != nil {
+			return err
+		}
+
+		if org != orgID || (orgID == 0 && owner != userID) {
+			return models.ErrNoRecord
+		}
+	}
+
+	if err = m.checkName(tx, userID, orgID, parentID, name, id); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE folders SET parent_id = ? WHERE id = ?`, nullID(parentID), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Return ErrDuplicateName if the parent folder has another subfolder with
+// the name. Folder except is not taken into account.
+func (m *FolderModel) checkName(q querier, userID, orgID, parentID int, name string, except int) error {
+	cond, args := spaceCond(userID, orgID)
+	stmt := `SELECT COUNT(*) FROM folders WHERE ` + cond + ` AND COALESCE(parent_id, 0) = ? AND name = ? AND id <> ?`
+
+	var n int
+	if err := q.QueryRow(stmt, append(args, parentID, name, except)...).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return models.ErrDuplicateName
+	}
+
+	return nil
+}
+
+// Return folders selected by stmt
+func (m *FolderModel) query(stmt string, args ...any) ([]*models.Folder, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []*models.Folder
+
+	for rows.Next() {
+		f := &models.Folder{}
+		if err = rows.Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.Created, &f.OrgID); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
@@ -0,0 +1,157 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type GrantModel struct {
+	DB *sql.DB
+}
+
+// Grant the user access to the file or the folder. Permission of an existing
+// grant for the same item is changed.
+func (m *GrantModel) Insert(g *models.Grant) error {
+	stmt := `INSERT INTO grants (owner_id, user_id, file_id, folder_id, permission, created)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE permission = VALUES(permission)`
+
+	_, err := m.DB.Exec(stmt, g.OwnerID, g.UserID, nullID(g.FileID), nullID(g.FolderID), g.Permission)
+	return err
+}
+
+// Return grant by ID
+func (m *GrantModel) Get(id int) (*models.Grant, error) {
+	stmt := `SELECT id, owner_id, user_id, COALESCE(file_id, 0), COALESCE(folder_id, 0), permission, created
+	FROM grants WHERE id = ?`
+
+	g := &models.Grant{}
+	err := m.DB.QueryRow(stmt, id).Scan(&g.ID, &g.OwnerID, &g.UserID, &g.FileID, &g.FolderID, &g.Permission, &g.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Delete the grant
+func (m *GrantModel) Delete(id int) error {
+	_, err := m.DB.Exec(`DELETE FROM grants WHERE id = ?`, id)
+	return err
+}
+
+// Return grants of the file with names of the users
+func (m *GrantModel) File(fileID int) ([]*models.Grant, error) {
+	return m.users(`g.file_id = ?`, fileID)
+}
+
+// Return grants of the folder with names of the users
+func (m *GrantModel) Folder(folderID int) ([]*models.Grant, error) {
+	return m.users(`g.folder_id = ?`, folderID)
+}
+
+// Return grants of the item matching cond with names of the users
+func (m *GrantModel) users(cond string, args ...any) ([]*models.Grant, error) {
+	stmt := `SELECT g.id, g.owner_id, g.user_id, COALESCE(g.file_id, 0), COALESCE(g.folder_id, 0), g.permission, g.created,
+	u.name, u.email FROM grants g JOIN users u ON u.id = g.user_id WHERE ` + cond + ` ORDER BY u.name`
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []*models.Grant
+
+	for rows.Next() {
+		g := &models.Grant{}
+		err = rows.Scan(&g.ID, &g.OwnerID, &g.UserID, &g.FileID, &g.FolderID, &g.Permission, &g.Created, &g.UserName, &g.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// Return files and folders shared with the user with their names, newest
+// first. Files in the trash are left out.
+func (m *GrantModel) Shared(userID int) ([]*models.Grant, error) {
+	stmt := `SELECT g.id, g.owner_id, g.user_id, COALESCE(g.file_id, 0), COALESCE(g.folder_id, 0), g.permission, g.created,
+	COALESCE(f.name, d.name), o.name
+	FROM grants g
+	JOIN users o ON o.id = g.owner_id
+	LEFT JOIN files f ON f.id = g.file_id
+	LEFT JOIN folders d ON d.id = g.folder_id
+	WHERE g.user_id = ? AND (g.file_id IS NULL OR f.deleted_at IS NULL)
+	ORDER BY g.created DESC, g.id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []*models.Grant
+
+	for rows.Next() {
+		g := &models.Grant{}
+		err = rows.Scan(&g.ID, &g.OwnerID, &g.UserID, &g.FileID, &g.FolderID, &g.Permission, &g.Created, &g.Name, &g.OwnerName)
+		if err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// Return the strongest permission the user was granted on the file, the
+// folder or any folder above it. Zero IDs are skipped, empty permission means
+// no access.
+func (m *GrantModel) Permission(userID, fileID, folderID int) (models.Permission, error) {
+	var best models.Permission
+
+	if fileID != 0 {
+		stmt := `SELECT permission FROM grants WHERE user_id = ? AND file_id = ?`
+		err := m.DB.QueryRow(stmt, userID, fileID).Scan(&best)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	// Grants of a folder apply to all its contents, so walk up to the root.
+	for id := folderID; id != 0; {
+		var p sql.NullString
+
+		stmt := `SELECT COALESCE(f.parent_id, 0), g.permission FROM folders f
+		LEFT JOIN grants g ON g.folder_id = f.id AND g.user_id = ? WHERE f.id = ?`
+		err := m.DB.QueryRow(stmt, userID, id).Scan(&id, &p)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if p.Valid && !best.Includes(models.Permission(p.String)) {
+			best = models.Permission(p.String)
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,202 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Failed password logins and lockouts of accounts. They are kept in the
+// database so all instances of the app count the same attempts.
+type LoginModel struct {
+	DB *sql.DB
+}
+
+// Begin a login attempt of the email from the IP address. The attempt is
+// recorded as failed before the credentials are checked, so parallel attempts
+// see each other. Attempts of the email are serialized on its lockout row, so
+// check judges the failures before the attempt and returns the reason to
+// refuse it, empty to allow it. The ID of the recorded attempt is returned,
+// 0 if it was refused.
+func (m *LoginModel) Attempt(email, ip string, since time.Time, check func(*models.LoginFailures) string) (int, string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	// A lockout row which ended long ago, if the email has none yet
+	stmt := `INSERT INTO login_lockouts (email, until, created)
+	VALUES(?, '1970-01-01 00:00:00', UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE id = id`
+
+	if _, err = tx.Exec(stmt, email); err != nil {
+		return 0, "", err
+	}
+
+	f, err := failures(tx, email, ip, since, true)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if refused := check(f); refused != "" {
+		return 0, refused, nil
+	}
+
+	stmt = `INSERT INTO login_failures (email, ip, created) VALUES(?, ?, UTC_TIMESTAMP())`
+
+	result, err := tx.Exec(stmt, email, ip)
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, "", err
+	}
+
+	return int(id), "", nil
+}
+
+// Forget the login attempt, which neither failed nor succeeded
+func (m *LoginModel) Release(id int) error {
+	_, err := m.DB.Exec(`DELETE FROM login_failures WHERE id = ?`, id)
+	return err
+}
+
+// Return failed logins of the email and of the IP address since the time
+// and the lockout of the email. Failures of the email before the end of its
+// latest lockout don't count anymore.
+func (m *LoginModel) Failures(email, ip string, since time.Time) (*models.LoginFailures, error) {
+	return failures(m.DB, email, ip, since, false)
+}
+
+// Return failures of the email and the IP address, locking the lockout row
+// of the email for the transaction if lock is set
+func failures(q querier, email, ip string, since time.Time, lock bool) (*models.LoginFailures, error) {
+	f := &models.LoginFailures{}
+
+	stmt := `SELECT until FROM login_lockouts WHERE email = ?`
+	if lock {
+		stmt += ` FOR UPDATE`
+	}
+
+	var until sql.NullTime
+	err := q.QueryRow(stmt, email).Scan(&until)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	accountSince := since
+	if until.Time.After(since) {
+		accountSince = until.Time
+	}
+	if until.Time.After(time.Now()) {
+		f.LockedUntil = until.Time
+	}
+
+	stmt = `SELECT
+	(SELECT COUNT(*) FROM login_failures WHERE email = ? AND created > ?),
+	(SELECT MAX(created) FROM login_failures WHERE email = ? AND created > ?),
+	(SELECT COUNT(*) FROM login_failures WHERE ip = ? AND created > ?)`
+
+	var last sql.NullTime
+	err = q.QueryRow(stmt, email, accountSince, email, accountSince, ip, since).Scan(&f.Account, &last, &f.IP)
+	if err != nil {
+		return nil, err
+	}
+	f.Last = last.Time
+
+	return f, nil
+}
+
+// Lock logins of the email until the time. Report whether the email wasn't
+// locked already.
+func (m *LoginModel) Lock(email string, until time.Time) (bool, error) {
+	// An expired lockout is replaced, an active one kept
+	stmt := `INSERT INTO login_lockouts (email, until, created) VALUES(?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE
+	created = IF(until > UTC_TIMESTAMP(), created, VALUES(created)),
+	until = IF(until > UTC_TIMESTAMP(), until, VALUES(until))`
+
+	result, err := m.DB.Exec(stmt, email, until)
+	if err != nil {
+		return false, err
+	}
+
+	// MySQL counts 1 for inserted, 2 for updated and 0 for unchanged rows
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Forget failed logins of the email after a successful one
+func (m *LoginModel) Succeed(email string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_failures WHERE email = ?`, email)
+	return err
+}
+
+// Return active lockouts, the latest first
+func (m *LoginModel) Lockouts() ([]*models.Lockout, error) {
+	stmt := `SELECT id, email, until, created FROM login_lockouts
+	WHERE until > UTC_TIMESTAMP() ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*models.Lockout{}
+	for rows.Next() {
+		l := &models.Lockout{}
+		if err = rows.Scan(&l.ID, &l.Email, &l.Until, &l.Created); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+// End the lockout now and return its email, so earlier failures of the email
+// don't count anymore. ErrNoRecord is returned if there is no such active
+// lockout.
+func (m *LoginModel) Unlock(id int) (string, error) {
+	var email string
+	err := m.DB.QueryRow(`SELECT email FROM login_lockouts WHERE id = ? AND until > UTC_TIMESTAMP()`, id).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", models.ErrNoRecord
+	} else if err != nil {
+		return "", err
+	}
+
+	_, err = m.DB.Exec(`UPDATE login_lockouts SET until = UTC_TIMESTAMP() WHERE id = ?`, id)
+	if err != nil {
+		return "", err
+	}
+
+	return email, nil
+}
+
+// Remove failed logins and lockouts which ended before the time
+func (m *LoginModel) DeleteBefore(t time.Time) error {
+	if _, err := m.DB.Exec(`DELETE FROM login_failures WHERE created < ?`, t); err != nil {
+		return err
+	}
+
+	_, err := m.DB.Exec(`DELETE FROM login_lockouts WHERE until < ?`, t)
+	return err
+}
@@ -0,0 +1,40 @@
+package mysql
+
+import "database/sql"
+
+// Implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Return NULL for zero ID, which means no reference
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// Return condition selecting rows of the space of the user or, if orgID is
+// not zero, of the organization, with its arguments
+func spaceCond(userID, orgID int) (string, []any) {
+	if orgID != 0 {
+		return `org_id = ?`, []any{orgID}
+	}
+
+	return `user_id = ? AND org_id IS NULL`, []any{userID}
+}
+
+// Lock the row of the user's or organization's space until the transaction
+// ends, so checks of names in the space don't interleave with the changes
+// relying on them
+func lockSpace(tx *sql.Tx, userID, orgID int) error {
+	table, id := spaceTable(userID, orgID)
+
+	var locked int
+	return tx.QueryRow(`SELECT id FROM `+table+` WHERE id = ? FOR UPDATE`, id).Scan(&locked)
+}
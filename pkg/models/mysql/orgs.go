@@ -0,0 +1,336 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type OrgModel struct {
+	DB *sql.DB
+}
+
+// Add a new organization with the user as its owner
+func (m *OrgModel) Insert(name string, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO orgs (name, created) VALUES(?, UTC_TIMESTAMP())`, name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO memberships (org_id, user_id, role, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	if _, err = tx.Exec(stmt, id, userID, models.RoleOwner); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Return organization data by ID
+func (m *OrgModel) Get(id int) (*models.Org, error) {
+	o := &models.Org{}
+
+	err := m.DB.QueryRow(`SELECT id, name, created FROM orgs WHERE id = ?`, id).Scan(&o.ID, &o.Name, &o.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Return organizations the user is a member of with the user's roles,
+// ordered by name
+func (m *OrgModel) ForUser(userID int) ([]*models.Org, error) {
+	stmt := `SELECT o.id, o.name, o.created, m.role FROM orgs o
+	JOIN memberships m ON m.org_id = o.id WHERE m.user_id = ? ORDER BY o.name`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []*models.Org
+
+	for rows.Next() {
+		o := &models.Org{}
+		if err = rows.Scan(&o.ID, &o.Name, &o.Created, &o.Role); err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// Columns of memberships m joined with users u, in order of queryMembers()
+const memberColumns = `m.org_id, m.user_id, u.name, u.email, m.role, m.created`
+
+// Return members of the organization ordered by name
+func (m *OrgModel) Members(orgID int) ([]*models.Member, error) {
+	stmt := `SELECT ` + memberColumns + ` FROM memberships m
+	JOIN users u ON u.id = m.user_id WHERE m.org_id = ? ORDER BY u.name`
+
+	return queryMembers(m.DB, stmt, orgID)
+}
+
+// Return membership of the user in the organization. Return ErrNoRecord if
+// the user is not a member.
+func (m *OrgModel) Member(orgID, userID int) (*models.Member, error) {
+	stmt := `SELECT ` + memberColumns + ` FROM memberships m
+	JOIN users u ON u.id = m.user_id WHERE m.org_id = ? AND m.user_id = ?`
+
+	members, err := queryMembers(m.DB, stmt, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	return members[0], nil
+}
+
+// Change role of the member. Return ErrLastOwner if the only owner would be
+// demoted.
+func (m *OrgModel) SetRole(orgID, userID int, role models.Role) error {
+	return m.changeMember(orgID, userID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE memberships SET role = ? WHERE org_id = ? AND user_id = ?`, role, orgID, userID)
+		return err
+	}, role == models.RoleOwner)
+}
+
+// Remove the member from the organization. Return ErrLastOwner if it's the
+// only owner.
+func (m *OrgModel) RemoveMember(orgID, userID int) error {
+	return m.changeMember(orgID, userID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM memberships WHERE org_id = ? AND user_id = ?`, orgID, userID)
+		return err
+	}, false)
+}
+
+// Apply change to the member within a transaction. Owners of the
+// organization are locked, so concurrent changes can't leave it without
+// owners unless the member stays owner.
+func (m *OrgModel) changeMember(orgID, userID int, change func(tx *sql.Tx) error, staysOwner bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT user_id FROM memberships WHERE org_id = ? AND role = ? FOR UPDATE`
+	owners, err := tx.Query(stmt, orgID, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	var isOwner bool
+	for owners.Next() {
+		var id int
+		if err = owners.Scan(&id); err != nil {
+			owners.Close()
+			return err
+		}
+		n++
+		isOwner = isOwner || id == userID
+	}
+	owners.Close()
+
+	if err = owners.Err(); err != nil {
+		return err
+	}
+
+	if isOwner && n == 1 && !staysOwner {
+		return models.ErrLastOwner
+	}
+
+	if err = change(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Invite the email to the organization. The invitation token is created on
+// behalf of the inviting user and valid for ttl.
+func (m *OrgModel) Invite(inv *models.Invitation, invitedBy int, ttl time.Duration) (*models.Token, error) {
+	t, err := generateToken(invitedBy, ttl, models.ScopeInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = insertToken(tx, t); err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO invitations (org_id, email, role, token_hash, created) VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+	if _, err = tx.Exec(stmt, inv.OrgID, inv.Email, inv.Role, t.Hash); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Columns of invitations i joined with orgs o and tokens t
+const invitationColumns = `i.id, i.org_id, o.name, i.email, i.role, i.created, t.expiry`
+
+// Return unexpired invitations of the organization, newest first
+func (m *OrgModel) Invitations(orgID int) ([]*models.Invitation, error) {
+	stmt := `SELECT ` + invitationColumns + ` FROM invitations i
+	JOIN orgs o ON o.id = i.org_id JOIN tokens t ON t.hash = i.token_hash
+	WHERE i.org_id = ? AND t.expiry > UTC_TIMESTAMP() ORDER BY i.created DESC`
+
+	rows, err := m.DB.Query(stmt, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*models.Invitation
+
+	for rows.Next() {
+		inv := &models.Invitation{}
+		if err = rows.Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.Email, &inv.Role, &inv.Created, &inv.Expires); err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, inv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Return unexpired invitation by the token plaintext
+func (m *OrgModel) Invitation(plaintext string) (*models.Invitation, error) {
+	stmt := `SELECT ` + invitationColumns + ` FROM invitations i
+	JOIN orgs o ON o.id = i.org_id JOIN tokens t ON t.hash = i.token_hash
+	WHERE i.token_hash = ? AND t.scope = ? AND t.expiry > UTC_TIMESTAMP()`
+
+	inv := &models.Invitation{}
+	err := m.DB.QueryRow(stmt, tokenHash(plaintext), models.ScopeInvitation).
+		Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.Email, &inv.Role, &inv.Created, &inv.Expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// Add the user to the organization of the invitation and use the token up.
+// Members keep their current role.
+func (m *OrgModel) Accept(plaintext string, userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orgID int
+	var role models.Role
+
+	stmt := `SELECT i.org_id, i.role FROM invitations i JOIN tokens t ON t.hash = i.token_hash
+	WHERE i.token_hash = ? AND t.expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, tokenHash(plaintext)).Scan(&orgID, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO memberships (org_id, user_id, role, created) VALUES(?, ?, ?, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE role = role`
+	if _, err = tx.Exec(stmt, orgID, userID, role); err != nil {
+		return err
+	}
+
+	// The invitation is deleted together with its token
+	if _, err = tx.Exec(`DELETE FROM tokens WHERE hash = ?`, tokenHash(plaintext)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Cancel the invitation of the organization
+func (m *OrgModel) CancelInvitation(orgID, id int) error {
+	var hash []byte
+
+	err := m.DB.QueryRow(`SELECT token_hash FROM invitations WHERE org_id = ? AND id = ?`, orgID, id).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	// The invitation is deleted together with its token
+	_, err = m.DB.Exec(`DELETE FROM tokens WHERE hash = ?`, hash)
+	return err
+}
+
+// Return members selected by stmt
+func queryMembers(q querier, stmt string, args ...any) ([]*models.Member, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*models.Member
+
+	for rows.Next() {
+		mb := &models.Member{}
+		if err = rows.Scan(&mb.OrgID, &mb.UserID, &mb.Name, &mb.Email, &mb.Role, &mb.Created); err != nil {
+			return nil, err
+		}
+
+		members = append(members, mb)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+// Add a registered credential and return its ID
+func (m *PasskeyModel) Insert(p *models.Passkey) (int, error) {
+	stmt := `INSERT INTO passkeys (user_id, name, credential_id, public_key, attestation_type, transports,
+	aaguid, sign_count, backup_eligible, backup_state, created)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, p.UserID, p.Name, p.CredentialID, p.PublicKey, p.AttestationType,
+		strings.Join(p.Transports, " "), p.AAGUID, p.SignCount, p.BackupEligible, p.BackupState)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Return credentials of the user, oldest first
+func (m *PasskeyModel) ForUser(userID int) ([]*models.Passkey, error) {
+	rows, err := m.DB.Query(passkeySelect+` WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*models.Passkey
+
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// Return the credential by the ID given by the authenticator
+func (m *PasskeyModel) GetByCredentialID(credentialID []byte) (*models.Passkey, error) {
+	p, err := scanPasskey(m.DB.QueryRow(passkeySelect+` WHERE credential_id = ?`, credentialID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	}
+
+	return p, err
+}
+
+// Record a login with the credential and its new signature counter
+func (m *PasskeyModel) Used(id int, signCount uint32, backupState bool) error {
+	stmt := `UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used = UTC_TIMESTAMP() WHERE id = ?`
+	_, err := m.DB.Exec(stmt, signCount, backupState, id)
+	return err
+}
+
+// Delete the credential of the user
+func (m *PasskeyModel) Delete(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM passkeys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+const passkeySelect = `SELECT id, user_id, name, credential_id, public_key, attestation_type, transports,
+	aaguid, sign_count, backup_eligible, backup_state, created, last_used FROM passkeys`
+
+func scanPasskey(row scanner) (*models.Passkey, error) {
+	p := &models.Passkey{}
+	var transports string
+	var lastUsed sql.NullTime
+
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.PublicKey, &p.AttestationType, &transports,
+		&p.AAGUID, &p.SignCount, &p.BackupEligible, &p.BackupState, &p.Created, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Transports = strings.Fields(transports)
+	p.LastUsed = lastUsed.Time
+
+	return p, nil
+}
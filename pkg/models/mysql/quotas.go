@@ -0,0 +1,147 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type QuotaModel struct {
+	DB *sql.DB
+}
+
+// Return storage usage of the user's personal space or, if orgID is not zero,
+// of the organization. Spaces without own quota have defaultQuota.
+func (m *QuotaModel) Usage(userID, orgID int, defaultQuota int64) (*models.Usage, error) {
+	table, id := spaceTable(userID, orgID)
+
+	stmt := `SELECT name, used, COALESCE(quota, ?), quota IS NOT NULL FROM ` + table + ` WHERE id = ?`
+
+	u := &models.Usage{UserID: userID, OrgID: orgID}
+	err := m.DB.QueryRow(stmt, defaultQuota, id).Scan(&u.Name, &u.Used, &u.Quota, &u.Custom)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Return storage usage of all users ordered by name
+func (m *QuotaModel) Users(defaultQuota int64) ([]*models.Usage, error) {
+	stmt := `SELECT id, 0, name, email, used, COALESCE(quota, ?), quota IS NOT NULL FROM users ORDER BY name`
+
+	return queryUsage(m.DB, stmt, defaultQuota)
+}
+
+// Return storage usage of all organizations ordered by name
+func (m *QuotaModel) Orgs(defaultQuota int64) ([]*models.Usage, error) {
+	stmt := `SELECT 0, id, name, '', used, COALESCE(quota, ?), quota IS NOT NULL FROM orgs ORDER BY name`
+
+	return queryUsage(m.DB, stmt, defaultQuota)
+}
+
+// Set quota of the user's personal space or, if orgID is not zero, of the
+// organization. DefaultQuota resets it to the configured default.
+func (m *QuotaModel) Set(userID, orgID int, quota int64) error {
+	table, id := spaceTable(userID, orgID)
+
+	value := sql.NullInt64{Int64: quota, Valid: quota != models.DefaultQuota}
+
+	result, err := m.DB.Exec(`UPDATE `+table+` SET quota = ? WHERE id = ?`, value, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL doesn't count rows which were not changed
+	if n == 0 {
+		var exists bool
+		err = m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM `+table+` WHERE id = ?)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return models.ErrNoRecord
+		}
+	}
+
+	return nil
+}
+
+// Add size bytes to the used storage of the space. Return ErrQuotaExceeded if
+// the space has not enough room left.
+func reserveSpace(q querier, userID, orgID int, size, defaultQuota int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	table, id := spaceTable(userID, orgID)
+
+	stmt := `UPDATE ` + table + ` SET used = used + ?
+	WHERE id = ? AND (COALESCE(quota, ?) = 0 OR used + ? <= COALESCE(quota, ?))`
+	result, err := q.Exec(stmt, size, id, defaultQuota, size, defaultQuota)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// Subtract size bytes from the used storage of the space
+func releaseSpace(q querier, userID, orgID int, size int64) error {
+	table, id := spaceTable(userID, orgID)
+
+	_, err := q.Exec(`UPDATE `+table+` SET used = GREATEST(used - ?, 0) WHERE id = ?`, size, id)
+	return err
+}
+
+// Return table and ID of the row holding usage of the space
+func spaceTable(userID, orgID int) (string, int) {
+	if orgID != 0 {
+		return "orgs", orgID
+	}
+
+	return "users", userID
+}
+
+// Return usage selected by stmt
+func queryUsage(q querier, stmt string, args ...any) ([]*models.Usage, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []*models.Usage
+
+	for rows.Next() {
+		u := &models.Usage{}
+		if err = rows.Scan(&u.UserID, &u.OrgID, &u.Name, &u.Email, &u.Used, &u.Quota, &u.Custom); err != nil {
+			return nil, err
+		}
+
+		usage = append(usage, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// One-time recovery codes for logins without the authenticator app. Only
+// their hashes are stored.
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// Replace recovery codes of the user with the codes
+func (m *RecoveryCodeModel) Replace(userID int, codes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)`, userID, tokenHash(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Mark the unused recovery code of the user as used. ErrInvalidCode is
+// returned for wrong and used codes.
+func (m *RecoveryCodeModel) Use(userID int, code string) error {
+	stmt := `UPDATE recovery_codes SET used = UTC_TIMESTAMP()
+	WHERE user_id = ? AND hash = ? AND used IS NULL`
+
+	result, err := m.DB.Exec(stmt, userID, tokenHash(code))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCode
+	}
+
+	return nil
+}
+
+// Return the number of unused recovery codes of the user
+func (m *RecoveryCodeModel) Remaining(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// Delete all recovery codes of the user
+func (m *RecoveryCodeModel) DeleteAll(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	return err
+}
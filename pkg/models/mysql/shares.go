@@ -0,0 +1,183 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ShareModel struct {
+	DB *sql.DB
+}
+
+// Add a new record to the shares table. The link is protected with password
+// unless it's empty.
+func (m *ShareModel) Insert(s *models.Share, password string) (int, error) {
+	var hashedPassword sql.NullString
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			return 0, err
+		}
+		hashedPassword = sql.NullString{String: string(hash), Valid: true}
+	}
+
+	var expires sql.NullTime
+	if !s.Expires.IsZero() {
+		expires = sql.NullTime{Time: s.Expires.UTC(), Valid: true}
+	}
+
+	var maxDownloads sql.NullInt64
+	if s.MaxDownloads > 0 {
+		maxDownloads = sql.NullInt64{Int64: int64(s.MaxDownloads), Valid: true}
+	}
+
+	stmt := `INSERT INTO shares (token, file_id, user_id, hashed_password, expires, max_downloads, created)
+	VALUES(?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, s.Token, s.FileID, s.UserID, hashedPassword, expires, maxDownloads)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Columns of shares s joined with files f, in order of scanShare()
+const shareColumns = `s.id, s.token, s.file_id, s.user_id, f.name, s.hashed_password IS NOT NULL, s.expires,
+	COALESCE(s.max_downloads, 0), s.downloads, s.created`
+
+// Return share link by token
+func (m *ShareModel) Get(token string) (*models.Share, error) {
+	stmt := `SELECT ` + shareColumns + ` FROM shares s JOIN files f ON f.id = s.file_id WHERE s.token = ?`
+
+	s, err := scanShare(m.DB.QueryRow(stmt, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Return all share links of the user, newest first
+func (m *ShareModel) All(userID int) ([]*models.Share, error) {
+	stmt := `SELECT ` + shareColumns + ` FROM shares s JOIN files f ON f.id = s.file_id
+	WHERE s.user_id = ? ORDER BY s.created DESC, s.id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var shares []*models.Share
+
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		shares = append(shares, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// Check the password of the share link. Return ErrInvalidCredentials if it
+// doesn't match.
+func (m *ShareModel) Authenticate(id int, password string) error {
+	var hashedPassword []byte
+
+	err := m.DB.QueryRow(`SELECT COALESCE(hashed_password, '') FROM shares WHERE id = ?`, id).Scan(&hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	// Links without password are open
+	if len(hashedPassword) == 0 {
+		return nil
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return models.ErrInvalidCredentials
+	}
+
+	return err
+}
+
+// Count a download through the share link. Return ErrShareExpired if the link
+// is expired or its downloads are used up.
+func (m *ShareModel) Download(id int) error {
+	stmt := `UPDATE shares SET downloads = downloads + 1 WHERE id = ?
+	AND (expires IS NULL OR expires > UTC_TIMESTAMP())
+	AND (max_downloads IS NULL OR downloads < max_downloads)`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrShareExpired
+	}
+
+	return nil
+}
+
+// Delete share link of the user. Return ErrNoRecord if the user has no such
+// link.
+func (m *ShareModel) Revoke(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM shares WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Scan share link selected with shareColumns
+func scanShare(row scanner) (*models.Share, error) {
+	s := &models.Share{}
+	var expires sql.NullTime
+
+	err := row.Scan(&s.ID, &s.Token, &s.FileID, &s.UserID, &s.FileName, &s.Protected, &expires, &s.MaxDownloads, &s.Downloads, &s.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Expires = expires.Time
+
+	return s, nil
+}
@@ -0,0 +1,241 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Generate a token of the user with the scope valid for ttl and add it to the
+// tokens table. The plaintext is returned to be sent to the user only.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (*models.Token, error) {
+	t, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertToken(m.DB, t)
+	return t, err
+}
+
+// Generate a personal access token of the user with the name and API scopes
+// valid for ttl and add it to the tokens table
+func (m *TokenModel) NewPersonal(userID int, name string, scopes []string, ttl time.Duration) (*models.Token, error) {
+	t, err := generateToken(userID, ttl, strings.Join(scopes, " "))
+	if err != nil {
+		return nil, err
+	}
+	t.Name = name
+
+	err = insertToken(m.DB, t)
+	return t, err
+}
+
+// Return personal access tokens of the user, newest first. Expired ones are
+// included.
+func (m *TokenModel) Personal(userID int) ([]*models.Token, error) {
+	stmt := `SELECT id, user_id, expiry, scope, name, COALESCE(created, expiry), last_used FROM tokens
+	WHERE user_id = ? AND name IS NOT NULL ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.Token
+
+	for rows.Next() {
+		t := &models.Token{}
+		var lastUsed sql.NullTime
+		if err = rows.Scan(&t.ID, &t.UserID, &t.Expiry, &t.Scope, &t.Name, &t.Created, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete the personal access token of the user
+func (m *TokenModel) DeletePersonal(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM tokens WHERE id = ? AND user_id = ? AND name IS NOT NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Return the unexpired API token by its plaintext, which is a token created
+// with the user's password or a personal access token, and record its use.
+// Last use is updated once a minute at most.
+func (m *TokenModel) Authenticate(plaintext string) (*models.Token, error) {
+	hash := tokenHash(plaintext)
+
+	stmt := `SELECT id, user_id, expiry, scope, COALESCE(name, '') FROM tokens
+	WHERE hash = ? AND (scope = ? OR name IS NOT NULL) AND expiry > UTC_TIMESTAMP()`
+
+	t := &models.Token{Plaintext: plaintext, Hash: hash}
+	err := m.DB.QueryRow(stmt, hash, models.ScopeAuthentication).Scan(&t.ID, &t.UserID, &t.Expiry, &t.Scope, &t.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	stmt = `UPDATE tokens SET last_used = UTC_TIMESTAMP()
+	WHERE hash = ? AND (last_used IS NULL OR last_used < UTC_TIMESTAMP() - INTERVAL 1 MINUTE)`
+	if _, err = m.DB.Exec(stmt, hash); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Return the unexpired token with the scope by its plaintext
+func (m *TokenModel) Get(scope, plaintext string) (*models.Token, error) {
+	hash := tokenHash(plaintext)
+
+	stmt := `SELECT hash, user_id, expiry, scope FROM tokens WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP()`
+
+	t := &models.Token{Plaintext: plaintext}
+	err := m.DB.QueryRow(stmt, hash, scope).Scan(&t.Hash, &t.UserID, &t.Expiry, &t.Scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Delete the unexpired token with the scope by its plaintext and return it.
+// Only one of concurrent requests gets the token, the others get ErrNoRecord,
+// so the token works once.
+func (m *TokenModel) Consume(scope, plaintext string) (*models.Token, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT hash, user_id, expiry, scope FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+
+	t := &models.Token{Plaintext: plaintext}
+	err = tx.QueryRow(stmt, tokenHash(plaintext), scope).Scan(&t.Hash, &t.UserID, &t.Expiry, &t.Scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM tokens WHERE hash = ? AND scope = ?`, t.Hash, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Delete the token by its plaintext
+func (m *TokenModel) Delete(plaintext string) error {
+	_, err := m.DB.Exec(`DELETE FROM tokens WHERE hash = ?`, tokenHash(plaintext))
+	return err
+}
+
+// Delete all tokens of the user with the scope
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM tokens WHERE scope = ? AND user_id = ?`, scope, userID)
+	return err
+}
+
+// Delete all tokens of the user, including personal access tokens.
+// Invitations the user sent stay valid.
+func (m *TokenModel) DeleteUser(userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM tokens WHERE user_id = ? AND scope <> ?`, userID, models.ScopeInvitation)
+	return err
+}
+
+// Return a token with random plaintext
+func generateToken(userID int, ttl time.Duration, scope string) (*models.Token, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	t := &models.Token{
+		Plaintext: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+	t.Hash = tokenHash(t.Plaintext)
+
+	return t, nil
+}
+
+// Add the token to the tokens table
+func insertToken(q querier, t *models.Token) error {
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope, name, created) VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	name := sql.NullString{String: t.Name, Valid: t.Name != ""}
+
+	result, err := q.Exec(stmt, t.Hash, t.UserID, t.Expiry.UTC(), t.Scope, name)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+	t.Created = time.Now()
+
+	return nil
+}
+
+// Return SHA-256 hash of the token plaintext stored in the tokens table
+func tokenHash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
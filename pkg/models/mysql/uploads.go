@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+// Add a new record to the uploads table.
+func (m *UploadModel) Insert(u *models.Upload) error {
+	stmt := `INSERT INTO uploads (id, user_id, name, type, length, upload_offset, created, expires, folder_id, org_id)
+	VALUES(?, ?, ?, ?, ?, 0, UTC_TIMESTAMP(), ?, ?, ?)`
+
+	_, err := m.DB.Exec(stmt, u.ID, u.UserID, u.Name, u.Type, u.Length, u.Expires.UTC(), nullID(u.FolderID), nullID(u.OrgID))
+	return err
+}
+
+// Return upload data by ID
+func (m *UploadModel) Get(id string) (*models.Upload, error) {
+	stmt := `SELECT id, user_id, name, type, length, upload_offset, created, expires, COALESCE(folder_id, 0), COALESCE(org_id, 0)
+	FROM uploads WHERE id = ?`
+
+	u := &models.Upload{}
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.UserID, &u.Name, &u.Type, &u.Length, &u.Offset, &u.Created, &u.Expires, &u.FolderID, &u.OrgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Record a stored chunk starting at offset and move the upload offset past
+// it. Return ErrOffsetMismatch if the upload is not at offset anymore, e.g.
+// because of a concurrent request.
+func (m *UploadModel) AddPart(id string, offset int64, size int64, storageKey string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE uploads SET upload_offset = upload_offset + ? WHERE id = ? AND upload_offset = ?`
+	result, err := tx.Exec(stmt, size, id, offset)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrOffsetMismatch
+	}
+
+	stmt = `INSERT INTO upload_parts (upload_id, part_offset, size, storage_key) VALUES(?, ?, ?, ?)`
+	if _, err = tx.Exec(stmt, id, offset, size, storageKey); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Return stored chunks of the upload ordered by offset
+func (m *UploadModel) Parts(id string) ([]*models.UploadPart, error) {
+	stmt := `SELECT part_offset, size, storage_key FROM upload_parts WHERE upload_id = ? ORDER BY part_offset`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []*models.UploadPart
+
+	for rows.Next() {
+		p := &models.UploadPart{}
+		if err = rows.Scan(&p.Offset, &p.Size, &p.StorageKey); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// Claim the complete upload for joining its chunks into a file. Only one
+// request wins the claim, false is returned if the upload was claimed
+// already. The claim is kept once the file is stored, so the upload reports
+// its full offset until it expires.
+func (m *UploadModel) Claim(id string) (bool, error) {
+	result, err := m.DB.Exec(`UPDATE uploads SET finished = TRUE WHERE id = ? AND NOT finished`, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Give up the claim of the upload after joining its chunks failed
+func (m *UploadModel) Unclaim(id string) error {
+	_, err := m.DB.Exec(`UPDATE uploads SET finished = FALSE WHERE id = ?`, id)
+	return err
+}
+
+// Delete the chunk records of the upload
+func (m *UploadModel) DeleteParts(id string) error {
+	_, err := m.DB.Exec(`DELETE FROM upload_parts WHERE upload_id = ?`, id)
+	return err
+}
+
+// Delete the upload and its chunk records
+func (m *UploadModel) Delete(id string) error {
+	_, err := m.DB.Exec(`DELETE FROM uploads WHERE id = ?`, id)
+	return err
+}
+
+// Return uploads expired before t
+func (m *UploadModel) Expired(t time.Time) ([]*models.Upload, error) {
+	stmt := `SELECT id, user_id, name, type, length, upload_offset, created, expires, COALESCE(folder_id, 0), COALESCE(org_id, 0)
+	FROM uploads WHERE expires < ?`
+
+	rows, err := m.DB.Query(stmt, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*models.Upload
+
+	for rows.Next() {
+		u := &models.Upload{}
+		err = rows.Scan(&u.ID, &u.UserID, &u.Name, &u.Type, &u.Length, &u.Offset, &u.Created, &u.Expires, &u.FolderID, &u.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"strings"
 
@@ -13,12 +14,13 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// Add a new record to the users table.
-func (m *UserModel) Insert(name, email, password string) error {
+// Add a new record to the users table and return its ID. The user has to
+// be activated before logging in.
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// Create a bcrypt hash of the plain-text password.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// SQL request we wanted to execute.
@@ -32,32 +34,41 @@ func (m *UserModel) Insert(name, email, password string) error {
 	// our users_uc_email key by checking the contents of the message string.
 	// If it does, we return an ErrDuplicateEmail error. Otherwise, we just
 	// return the original error (or nil if everything worked).
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			// if mysqlErr.Number == 1062 {
 			// 	return models.ErrDuplicateEmail
 			// }
 			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "Duplicate entry") {
-				return models.ErrDuplicateEmail
+				return 0, models.ErrDuplicateEmail
 			}
 		}
+		return 0, err
 	}
 
-	return err
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 // Verify whether a user exists with the provided email address and password.
-// Return the relevant user ID if they do.
+// Return the relevant user ID if they do. ErrNotActivated is returned for
+// valid credentials of users who haven't confirmed their email yet. Users of
+// the LDAP directory are checked by the directory only.
 func (m *UserModel) Authenticate(email, password string) (int, string, error) {
 	var id int
 	var name string
 	var hashedPassword []byte
+	var activated bool
 
 	// Retrieve the id and hashed password associated with the given email. If
 	// matching email exists, we return the ErrInvalidCredentials error.
-	row := m.DB.QueryRow("SELECT id, name, hashed_password FROM users WHERE email = ?", email)
-	err := row.Scan(&id, &name, &hashedPassword)
+	row := m.DB.QueryRow("SELECT id, name, hashed_password, activated FROM users WHERE email = ? AND ldap_dn IS NULL", email)
+	err := row.Scan(&id, &name, &hashedPassword, &activated)
 	if err == sql.ErrNoRows {
 		return 0, "", models.ErrInvalidCredentials
 	} else if err != nil {
@@ -73,15 +84,227 @@ func (m *UserModel) Authenticate(email, password string) (int, string, error) {
 		return 0, "", err
 	}
 
+	if !activated {
+		return 0, "", models.ErrNotActivated
+	}
+
 	return id, name, nil
 }
 
+// Replace the user's password and log out existing sessions. The user is
+// activated as well since the reset link proves the email address.
+func (m *UserModel) SetPassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ?, activated = TRUE, session_version = session_version + 1
+	WHERE id = ?`
+
+	_, err = m.DB.Exec(stmt, string(hashedPassword), id)
+	return err
+}
+
+// Set the TOTP secret of the user, an empty secret turns two-factor
+// authentication off
+func (m *UserModel) SetTOTP(id int, secret string) error {
+	stmt := `UPDATE users SET totp_secret = NULLIF(?, ''), totp_step = NULL WHERE id = ?`
+	_, err := m.DB.Exec(stmt, secret, id)
+	return err
+}
+
+// Set how many prior versions of each file the user keeps,
+// DefaultMaxVersions for the configured number
+func (m *UserModel) SetMaxVersions(id, n int) error {
+	value := sql.NullInt64{Int64: int64(n), Valid: n != models.DefaultMaxVersions}
+
+	_, err := m.DB.Exec(`UPDATE users SET max_versions = ? WHERE id = ?`, value, id)
+	return err
+}
+
+// Record the time step of a used one-time code. ErrInvalidCode is returned
+// if a code of the step or a later one was used already.
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	stmt := `UPDATE users SET totp_step = ? WHERE id = ? AND (totp_step IS NULL OR totp_step < ?)`
+
+	result, err := m.DB.Exec(stmt, step, id, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCode
+	}
+
+	return nil
+}
+
+// Add an activated user signed in with an OpenID Connect provider and
+// return its ID. The password is random, users can set one by resetting it.
+func (m *UserModel) InsertOIDC(name, email, issuer, subject string) (int, error) {
+	hashedPassword, err := randomPassword()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created, activated, oidc_issuer, oidc_subject)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), TRUE, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword), issuer, subject)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "Duplicate entry") {
+				return 0, models.ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Link the activated user to the subject of an OpenID Connect provider.
+// ErrNoRecord is returned if the user isn't activated or is linked to another
+// subject already.
+func (m *UserModel) LinkOIDC(id int, issuer, subject string) error {
+	stmt := `UPDATE users SET oidc_issuer = ?, oidc_subject = ?
+	WHERE id = ? AND activated = TRUE AND oidc_subject IS NULL`
+
+	result, err := m.DB.Exec(stmt, issuer, subject, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Hand the user which was never activated over to the subject of an OpenID
+// Connect provider verifying the email. Whoever registered the user didn't
+// prove the email, so the user is replaced as if it was new: the password is
+// random, sessions are logged out and pending tokens are deleted.
+// ErrNoRecord is returned if the user is activated or linked already.
+func (m *UserModel) ReplaceOIDC(id int, name, issuer, subject string) error {
+	hashedPassword, err := randomPassword()
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET name = ?, hashed_password = ?, activated = TRUE,
+	session_version = session_version + 1, oidc_issuer = ?, oidc_subject = ?
+	WHERE id = ? AND activated = FALSE AND oidc_subject IS NULL`
+
+	result, err := tx.Exec(stmt, name, string(hashedPassword), issuer, subject, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	if _, err = tx.Exec(`DELETE FROM tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create or update the user of the LDAP directory entry and return its ID.
+// Users are found by the DN of the entry, otherwise the user with the same
+// email is linked to it. Name, email and administrator rights follow the
+// directory.
+func (m *UserModel) SyncLDAP(dn, name, email string, admin bool) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM users WHERE ldap_dn = ? FOR UPDATE", dn).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow("SELECT id FROM users WHERE email = ? AND ldap_dn IS NULL FOR UPDATE", email).Scan(&id)
+	}
+
+	switch {
+	case err == nil:
+		stmt := `UPDATE users SET ldap_dn = ?, name = ?, email = ?, admin = ?, activated = TRUE WHERE id = ?`
+		_, err = tx.Exec(stmt, dn, name, email, admin, id)
+	case err == sql.ErrNoRows:
+		id, err = insertLDAP(tx, dn, name, email, admin)
+	}
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "Duplicate entry") {
+				return 0, models.ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Add an activated user of the LDAP directory with a random password
+func insertLDAP(tx *sql.Tx, dn, name, email string, admin bool) (int, error) {
+	hashedPassword, err := randomPassword()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created, activated, ldap_dn, admin)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), TRUE, ?, ?)`
+
+	result, err := tx.Exec(stmt, name, email, string(hashedPassword), dn, admin)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Mark the user's email address as confirmed
+func (m *UserModel) Activate(id int) error {
+	_, err := m.DB.Exec("UPDATE users SET activated = TRUE WHERE id = ?", id)
+	return err
+}
+
 // Fetch details for a specific user based on their user ID.
 func (m *UserModel) Get(id int) (*models.User, error) {
 	s := &models.User{}
 
-	stmt := `SELECT id, name, email, created FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created)
+	stmt := `SELECT id, name, email, created, activated, session_version, COALESCE(totp_secret, ''),
+	admin, ldap_dn IS NOT NULL, COALESCE(max_versions, -1) FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created, &s.Activated, &s.SessionVersion, &s.TOTPSecret, &s.Admin, &s.LDAP, &s.MaxVersions)
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
@@ -90,3 +313,47 @@ func (m *UserModel) Get(id int) (*models.User, error) {
 
 	return s, nil
 }
+
+// Fetch details for a specific user based on their email address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	s := &models.User{}
+
+	stmt := `SELECT id, name, email, created, activated, session_version, COALESCE(totp_secret, ''),
+	admin, ldap_dn IS NOT NULL, COALESCE(max_versions, -1) FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&s.ID, &s.Name, &s.Email, &s.Created, &s.Activated, &s.SessionVersion, &s.TOTPSecret, &s.Admin, &s.LDAP, &s.MaxVersions)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Fetch details for the user linked to the subject of an OpenID Connect
+// provider.
+func (m *UserModel) GetByOIDC(issuer, subject string) (*models.User, error) {
+	s := &models.User{}
+
+	stmt := `SELECT id, name, email, created, activated, session_version, COALESCE(totp_secret, ''),
+	admin, ldap_dn IS NOT NULL, COALESCE(max_versions, -1) FROM users WHERE oidc_issuer = ? AND oidc_subject = ?`
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&s.ID, &s.Name, &s.Email, &s.Created, &s.Activated, &s.SessionVersion, &s.TOTPSecret, &s.Admin, &s.LDAP, &s.MaxVersions)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Return the hash of a random password of users signing in elsewhere. They
+// can set a password by resetting it.
+func randomPassword() ([]byte, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+
+	return bcrypt.GenerateFromPassword(password, 12)
+}
@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/alekslesik/file-cloud/pkg/models"
+)
+
+// Columns of file_versions v joined with users u, in order of queryVersions()
+const versionColumns = `v.id, v.file_id, v.type, v.size, v.checksum, v.created, v.storage_key, v.user_id, COALESCE(u.name, '')`
+
+// Return prior versions of the file, newest first
+func (m *FileModel) Versions(fileID int) ([]*models.FileVersion, error) {
+	stmt := `SELECT ` + versionColumns + ` FROM file_versions v
+	LEFT JOIN users u ON u.id = v.user_id WHERE v.file_id = ? ORDER BY v.created DESC, v.id DESC`
+
+	return queryVersions(m.DB, stmt, fileID)
+}
+
+// Return prior version of the file by ID
+func (m *FileModel) Version(fileID, id int) (*models.FileVersion, error) {
+	stmt := `SELECT ` + versionColumns + ` FROM file_versions v
+	LEFT JOIN users u ON u.id = v.user_id WHERE v.file_id = ? AND v.id = ?`
+
+	versions, err := queryVersions(m.DB, stmt, fileID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	return versions[0], nil
+}
+
+// Make the prior version current. The current contents become a prior
+// version in turn, so nothing is lost and blob references stay the same.
+func (m *FileModel) RestoreVersion(fileID, id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var v models.FileVersion
+	var blobHash sql.NullString
+
+	stmt := `SELECT type, size, checksum, blob_hash, storage_key, user_id, created FROM file_versions
+	WHERE file_id = ? AND id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, fileID, id).Scan(&v.Type, &v.Size, &v.Checksum, &blobHash, &v.StorageKey, &v.UploaderID, &v.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	if err = saveVersion(tx, fileID); err != nil {
+		return err
+	}
+
+	stmt = `UPDATE files SET type = ?, size = ?, checksum = ?, blob_hash = ?, storage_key = ?, uploader_id = ?,
+	created = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, v.Type, v.Size, v.Checksum, blobHash, v.StorageKey, v.UploaderID, v.Created, fileID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM file_versions WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete all but keep newest prior versions of the file. Their blobs are
+// released the same way as by Delete().
+func (m *FileModel) PruneVersions(fileID, keep int, remove func(storageKey string) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// MySQL supports OFFSET only together with LIMIT
+	stmt := `SELECT ` + versionColumns + ` FROM file_versions v
+	LEFT JOIN users u ON u.id = v.user_id WHERE v.file_id = ?
+	ORDER BY v.created DESC, v.id DESC LIMIT 18446744073709551615 OFFSET ? FOR UPDATE`
+	versions, err := queryVersions(tx, stmt, fileID, keep)
+	if err != nil {
+		return err
+	}
+
+	var unused []contents
+	for _, v := range versions {
+		c, err := deleteVersion(tx, v.ID)
+		if err != nil {
+			return err
+		}
+		unused = append(unused, c...)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return m.removeContents(unused, remove)
+}
+
+// Copy the current contents of the file to a new prior version
+func saveVersion(tx *sql.Tx, fileID int) error {
+	stmt := `INSERT INTO file_versions (file_id, type, size, checksum, blob_hash, storage_key, user_id, created)
+	SELECT id, type, size, checksum, blob_hash, storage_key, COALESCE(uploader_id, user_id), created FROM files WHERE id = ?`
+	_, err := tx.Exec(stmt, fileID)
+
+	return err
+}
+
+// Delete the prior version, free its storage in the space of the file and
+// release its contents. Contents which aren't used anymore are returned.
+func deleteVersion(tx *sql.Tx, id int) ([]contents, error) {
+	var blobHash sql.NullString
+	var storageKey string
+	var size int64
+	var userID, orgID int
+
+	stmt := `SELECT v.blob_hash, v.storage_key, v.size, f.user_id, COALESCE(f.org_id, 0)
+	FROM file_versions v JOIN files f ON f.id = v.file_id WHERE v.id = ?`
+	if err := tx.QueryRow(stmt, id).Scan(&blobHash, &storageKey, &size, &userID, &orgID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM file_versions WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+
+	if err := releaseSpace(tx, userID, orgID, size); err != nil {
+		return nil, err
+	}
+
+	return releaseContents(tx, blobHash, storageKey)
+}
+
+// Return file versions selected by stmt
+func queryVersions(q querier, stmt string, args ...any) ([]*models.FileVersion, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var versions []*models.FileVersion
+
+	for rows.Next() {
+		v := &models.FileVersion{}
+
+		err = rows.Scan(&v.ID, &v.FileID, &v.Type, &v.Size, &v.Checksum, &v.Created, &v.StorageKey, &v.UploaderID, &v.UploaderName)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
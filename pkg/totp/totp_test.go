@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Base32 of the SHA1 secret "12345678901234567890" of RFC 6238 Appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The 8 digit SHA1 codes of RFC 6238 Appendix B, of which 6 digit codes
+	// are the last 6 digits
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := tt.rfc[2:]; code != want {
+			t.Errorf("Code at %d: got %q; want %q", tt.unix, code, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// Step 37037036, the code is 081804
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / period
+
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "081804", now, current, true},
+		{"with spaces", " 081 804 ", now, current, true},
+		{"previous step", "081804", now.Add(period * time.Second), current, true},
+		{"next step", "081804", now.Add(-period * time.Second), current, true},
+		{"beyond skew", "081804", now.Add(2 * period * time.Second), 0, false},
+		{"wrong code", "081805", now, 0, false},
+		{"short code", "81804", now, 0, false},
+		{"RFC 8 digit code", "07081804", now, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, tt.at)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("got %d, %t; want %d, %t", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "123456", time.Now()); ok {
+		t.Error("got valid code of an invalid secret")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcde-fghij", "abcde-fghij"},
+		{" ABCDE-FGHIJ ", "abcde-fghij"},
+		{"abcdefghij", "abcde-fghij"},
+		{"abcde fghij", "abcde-fghij"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q; want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -12,39 +12,32 @@ $('.input-file input[type=file]').on('change', function () {
 	$(this).next().html(file.name);
 });
 
-// Function to load file by URL
-function downloadFile(url) {
-	const link = $('<a>');
-	link.attr('href', url);
-	link.attr('download', url.split('/').pop());
-	$('body').append(link);
-	link[0].click();
-	link.remove();
-}
-
-// Handler when hover
-$(document).on('mouseover', function (e) {
-	if ($(e.target).hasClass('file')) {
-		const fileUrl = $(e.target).data('file-url');
-		$(e.target).css('cursor', 'pointer');
-		$(e.target).on('click', function () {
-			downloadFile(fileUrl);
-		});
-	}
-});
-
 $(document).ready(function () {
     $('#file').change(function () {
         var fileName = $(this).val().split('\\').pop();
         $('#file-placeholder').text(fileName || 'Choose file');
     });
 
+    $('form[data-confirm]').submit(function (event) {
+        if (!confirm($(this).data('confirm'))) {
+            event.preventDefault();
+        }
+    });
+
     $('#form').submit(function (event) {
         var fileInput = $('#file')[0];
 
         if (!fileInput.files || !fileInput.files[0]) {
             event.preventDefault();
             alert('Please choose a file');
+            return;
+        }
+
+        // Don't send files which don't fit into the storage quota
+        var left = $(this).data('left');
+        if (left !== undefined && fileInput.files[0].size > left) {
+            event.preventDefault();
+            alert('Not enough storage, ' + $(this).data('left-text') + ' left');
         }
     });
 });
@@ -0,0 +1,110 @@
+// Passkey registration and login with the WebAuthn browser API. The server
+// sends options and expects responses with binary fields as base64url.
+(function () {
+	function toBuffer(value) {
+		var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+		while (base64.length % 4) {
+			base64 += '=';
+		}
+		return Uint8Array.from(atob(base64), function (c) { return c.charCodeAt(0); }).buffer;
+	}
+
+	function toBase64URL(buffer) {
+		var bytes = new Uint8Array(buffer);
+		var binary = '';
+		for (var i = 0; i < bytes.length; i++) {
+			binary += String.fromCharCode(bytes[i]);
+		}
+		return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+	}
+
+	function post(url, form, body) {
+		return fetch(url, {
+			method: 'POST',
+			credentials: 'same-origin',
+			headers: {
+				'Content-Type': 'application/json',
+				'X-CSRF-Token': form.elements['csrf_token'].value
+			},
+			body: body === undefined ? null : JSON.stringify(body)
+		}).then(function (response) {
+			return response.json().then(function (data) {
+				if (!response.ok) {
+					throw new Error(data.error || 'Request failed');
+				}
+				return data;
+			});
+		});
+	}
+
+	function showError(id, err) {
+		var el = document.getElementById(id);
+		el.textContent = err.name === 'NotAllowedError' ? 'The passkey prompt was cancelled' : err.message;
+		el.hidden = false;
+	}
+
+	var register = document.getElementById('passkey-register');
+	if (register) {
+		register.addEventListener('submit', function (event) {
+			event.preventDefault();
+
+			post('/user/passkeys/register/begin', register).then(function (options) {
+				var publicKey = options.publicKey;
+				publicKey.challenge = toBuffer(publicKey.challenge);
+				publicKey.user.id = toBuffer(publicKey.user.id);
+				(publicKey.excludeCredentials || []).forEach(function (c) {
+					c.id = toBuffer(c.id);
+				});
+				return navigator.credentials.create({ publicKey: publicKey });
+			}).then(function (credential) {
+				var name = encodeURIComponent(register.elements['name'].value);
+				return post('/user/passkeys/register/finish?name=' + name, register, {
+					id: credential.id,
+					rawId: toBase64URL(credential.rawId),
+					type: credential.type,
+					response: {
+						attestationObject: toBase64URL(credential.response.attestationObject),
+						clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+						transports: credential.response.getTransports ? credential.response.getTransports() : []
+					}
+				});
+			}).then(function (data) {
+				window.location = data.redirect;
+			}).catch(function (err) {
+				showError('passkey-register-error', err);
+			});
+		});
+	}
+
+	var login = document.getElementById('passkey-login');
+	if (login) {
+		login.addEventListener('submit', function (event) {
+			event.preventDefault();
+
+			post('/user/login/passkey/begin', login).then(function (options) {
+				var publicKey = options.publicKey;
+				publicKey.challenge = toBuffer(publicKey.challenge);
+				(publicKey.allowCredentials || []).forEach(function (c) {
+					c.id = toBuffer(c.id);
+				});
+				return navigator.credentials.get({ publicKey: publicKey });
+			}).then(function (credential) {
+				return post('/user/login/passkey/finish', login, {
+					id: credential.id,
+					rawId: toBase64URL(credential.rawId),
+					type: credential.type,
+					response: {
+						authenticatorData: toBase64URL(credential.response.authenticatorData),
+						clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+						signature: toBase64URL(credential.response.signature),
+						userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : null
+					}
+				});
+			}).then(function (data) {
+				window.location = data.redirect;
+			}).catch(function (err) {
+				showError('passkey-login-error', err);
+			});
+		});
+	}
+})();